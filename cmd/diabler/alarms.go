@@ -0,0 +1,445 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tetra5/diabler/pkg/clock"
+	"github.com/tetra5/diabler/pkg/d4/events"
+	"github.com/tetra5/diabler/pkg/menu"
+	"github.com/tetra5/diabler/pkg/store"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// kindDigest marks jobs holding alarms deferred by quiet hours.
+const kindDigest events.Kind = "digest"
+
+const (
+	maxAlarmAttempts = 3
+	alarmRetryDelay  = 30 * time.Second
+	alarmTolerance   = time.Minute // How late an alarm is still on time, matters for alarms at event start
+	maxAlarmWait     = time.Hour   // Upper bound of a single sleep, keeps clock jumps from going unnoticed
+	maxSkippedEvents = 100         // Events looked through for one the user wants an alarm for
+)
+
+// AlarmScheduler queues the next alarm of every user's lead time of each event kind as a store
+// job and sends it when due. Jobs are persisted so pending alarms survive restarts. A single
+// goroutine runs everything off a min-heap of due times: all users are looked at once on
+// start-up, after that only the user whose alarm went off or whose settings changed. Alarms are
+// posted without waiting for them, so a chat held back by flood limits does not hold back the
+// others, and the results are picked up by the same goroutine.
+type AlarmScheduler struct {
+	Clock   clock.Clock
+	st      store.Store
+	kinds   []EventKind
+	bot     menu.Poster
+	queue   jobQueue
+	pending map[alarmKey]store.Job // Queued or sending job of each user's alarm lead time
+
+	mu          sync.Mutex
+	rescheduled map[int64]bool       // Chat IDs to reschedule
+	replanned   map[events.Kind]bool // Kinds whose schedule has changed
+	sent        []sendResult         // Results of posted alarms
+	wake        chan struct{}
+}
+
+type sendResult struct {
+	job store.Job
+	err error
+}
+
+type alarmKey struct {
+	chatID int64
+	kind   events.Kind
+	lead   int // Minutes
+}
+
+func NewAlarmScheduler(st store.Store, kinds []EventKind, bot menu.Poster) *AlarmScheduler {
+	return &AlarmScheduler{
+		Clock:       clock.Real{},
+		st:          st,
+		kinds:       kinds,
+		bot:         bot,
+		pending:     map[alarmKey]store.Job{},
+		rescheduled: map[int64]bool{},
+		replanned:   map[events.Kind]bool{},
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Reschedule makes the scheduler pick up changed alarm settings of a user. It is safe to call
+// from any goroutine and never blocks, repeated calls before Run gets to them count once.
+func (s *AlarmScheduler) Reschedule(chatID int64) {
+	s.mu.Lock()
+	s.rescheduled[chatID] = true
+	s.mu.Unlock()
+	s.notify()
+}
+
+// Replan makes the scheduler drop the queued alarms of every user for events of the given kind
+// and plan them anew, after the schedule of the kind has changed. Like Reschedule it never
+// blocks.
+func (s *AlarmScheduler) Replan(kind events.Kind) {
+	s.mu.Lock()
+	s.replanned[kind] = true
+	s.mu.Unlock()
+	s.notify()
+}
+
+func (s *AlarmScheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default: // Run has yet to pick up an earlier call, it will see this one too
+	}
+}
+
+// Run is the scheduler loop, it returns when ctx is done.
+func (s *AlarmScheduler) Run(ctx context.Context) {
+	s.rehydrate()
+	for {
+		wait := s.deliverDue()
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+			s.applyChanges()
+		case <-s.Clock.After(wait):
+		}
+	}
+}
+
+// applyChanges takes the results of posted alarms and the changes requested by Reschedule and
+// Replan since the last call.
+func (s *AlarmScheduler) applyChanges() {
+	s.mu.Lock()
+	sent, rescheduled, replanned := s.sent, s.rescheduled, s.replanned
+	s.sent, s.rescheduled, s.replanned = nil, map[int64]bool{}, map[events.Kind]bool{}
+	s.mu.Unlock()
+	for _, res := range sent {
+		s.finish(res.job, res.err)
+	}
+	for kind := range replanned {
+		s.replanKind(kind)
+	}
+	for chatID := range rescheduled {
+		s.scheduleUser(chatID)
+	}
+}
+
+// rehydrate loads pending jobs and plans alarms of users that have none. Jobs that were being
+// sent when the process stopped are dropped: whether they got through is unknown and a repeated
+// alarm is worse than a missing one.
+func (s *AlarmScheduler) rehydrate() {
+	jobs, err := s.st.Jobs()
+	if err != nil {
+		log.Printf("Error loading alarm jobs: %s", err)
+	}
+	for _, j := range jobs {
+		if !j.ClaimedAt.IsZero() {
+			log.Printf("Dropping %s alarm for %d interrupted while sending at %s", j.Kind, j.ChatID, j.ClaimedAt)
+			s.deleteJob(j)
+			continue
+		}
+		s.push(j)
+	}
+	log.Printf("Alarm jobs pending: %d", len(s.queue))
+	users, err := s.st.Users()
+	if err != nil {
+		log.Printf("Error loading users: %s", err)
+		return
+	}
+	for _, u := range users {
+		for _, k := range s.kinds {
+			s.schedule(u, k)
+		}
+	}
+}
+
+func (s *AlarmScheduler) scheduleUser(chatID int64) {
+	u, err := s.st.GetUser(chatID)
+	if err != nil {
+		log.Printf("Error loading user %d: %s", chatID, err)
+		return
+	}
+	for _, k := range s.kinds {
+		s.schedule(u, k)
+	}
+}
+
+// replanKind drops the queued alarms of the given kind and plans them again for every user. The
+// dropped jobs stay in the heap until due, deliverDue discards them as superseded. Alarms being
+// sent are left to finish.
+func (s *AlarmScheduler) replanKind(kind events.Kind) {
+	for key, j := range s.pending {
+		if key.kind == kind && j.ClaimedAt.IsZero() {
+			s.deleteJob(j)
+			delete(s.pending, key)
+		}
+	}
+	users, err := s.st.Users()
+	if err != nil {
+		log.Printf("Error loading users: %s", err)
+		return
+	}
+	for _, u := range users {
+		for _, k := range s.kinds {
+			if k.Schedule.Kind() == kind {
+				s.schedule(u, k)
+			}
+		}
+	}
+}
+
+// schedule queues the user's next alarm of every lead time of the given kind.
+func (s *AlarmScheduler) schedule(u store.User, k EventKind) {
+	for _, timer := range *u.Alarms(k.Schedule.Kind()) {
+		s.scheduleLead(u, k, timer)
+	}
+}
+
+// scheduleLead queues the user's next alarm going off timer minutes before an event unless one
+// is queued already. Queued alarms of removed lead times are left to be dropped when due.
+func (s *AlarmScheduler) scheduleLead(u store.User, k EventKind, timer int) {
+	now := s.Clock.Now()
+	lead := time.Duration(timer) * time.Minute
+	key := alarmKey{u.ChatID, k.Schedule.Kind(), timer}
+	next := k.Schedule.NextEventAt(now)
+	for i := 0; !next.Start().Add(-lead).After(now) || !u.WantsAlarm(next); i++ {
+		if i == maxSkippedEvents {
+			return // Everything is muted
+		}
+		next = k.Schedule.NextEventAt(next.Start())
+	}
+	job := store.Job{
+		ChatID:     u.ChatID,
+		Kind:       k.Schedule.Kind(),
+		EventStart: next.Start(),
+		Title:      next.Title(),
+		AlarmTimer: timer,
+		Due:        next.Start().Add(-lead),
+		Zone:       next.Zone(),
+	}
+	pending, ok := s.pending[key]
+	if ok && !job.EventStart.Before(pending.EventStart) {
+		return // Replaced only by an earlier one, after a boss got unmuted
+	}
+	log.Printf("Setting %s %s timer for %d ...", job.Due.Sub(now).String(), k.Label, u.ChatID)
+	if err := s.st.PutJob(job); err != nil {
+		log.Printf("Error saving alarm job: %s", err)
+		return
+	}
+	if ok {
+		// Otherwise it would be queued again on restart, next to the job replacing it
+		s.deleteJob(pending)
+	}
+	s.push(job)
+}
+
+func (s *AlarmScheduler) push(j store.Job) {
+	s.pending[alarmKey{j.ChatID, j.Kind, j.AlarmTimer}] = j
+	heap.Push(&s.queue, j)
+}
+
+// deliverDue sends every due job and returns how long to wait for the next one. Overdue jobs
+// are still sent as long as their event has not started, they are dropped otherwise. So are
+// jobs whose alarm the user has removed or whose boss they have muted since. Alarms due during
+// the user's quiet hours are dropped or deferred, see quiet.
+func (s *AlarmScheduler) deliverDue() (wait time.Duration) {
+	now := s.Clock.Now()
+	for len(s.queue) > 0 && !s.queue[0].Due.After(now) {
+		j := heap.Pop(&s.queue).(store.Job)
+		key := alarmKey{j.ChatID, j.Kind, j.AlarmTimer}
+		pending := s.pending[key]
+		if pending.ID() != j.ID() {
+			// Superseded by a job queued after a settings change
+			s.deleteJob(j)
+			continue
+		}
+		if !pending.ClaimedAt.IsZero() {
+			continue // Being sent already, the job got queued twice after a settings change
+		}
+		j = pending // Digests get new lines after they are queued
+		u, err := s.st.GetUser(j.ChatID)
+		if err != nil {
+			if !errors.Is(err, store.ErrNotFound) {
+				log.Printf("Error loading user %d: %s", j.ChatID, err)
+			}
+			delete(s.pending, key)
+			s.deleteJob(j)
+			continue
+		}
+		if j.Kind == kindDigest {
+			s.deliver(j, now)
+			continue
+		}
+		if !u.HasAlarm(j.Kind, j.AlarmTimer) {
+			log.Printf("Dropping %s alarm for %d: alarm has been removed", j.Kind, j.ChatID)
+			s.deleteJob(j)
+			continue
+		}
+		if j.Kind == events.KindWorldBoss && u.MutesBoss(j.Title) {
+			log.Printf("Dropping %s alarm for %d: %s is muted", j.Kind, j.ChatID, j.Title)
+			s.deleteJob(j)
+		} else if now.After(j.Due.Add(alarmTolerance)) && !now.Before(j.EventStart) {
+			log.Printf("Dropping %s alarm for %d: event has already started", j.Kind, j.ChatID)
+			s.deleteJob(j)
+		} else if u.InQuietHours(now) {
+			s.quiet(u, j)
+		} else {
+			// The next alarm is queued once this one is sent or given up on, see finish
+			s.deliver(j, now)
+			continue
+		}
+		delete(s.pending, key)
+		s.scheduleNext(u, j)
+	}
+	wait = maxAlarmWait
+	if len(s.queue) > 0 && s.queue[0].Due.Sub(now) < wait {
+		wait = s.queue[0].Due.Sub(now)
+	}
+	return wait
+}
+
+// quiet drops an alarm falling into the user's quiet hours or moves it to the digest sent when
+// they are over.
+func (s *AlarmScheduler) quiet(u store.User, j store.Job) {
+	s.deleteJob(j)
+	if !u.QuietDigest {
+		log.Printf("Dropping %s alarm for %d: quiet hours", j.Kind, j.ChatID)
+		return
+	}
+	key := alarmKey{u.ChatID, kindDigest, 0}
+	digest, ok := s.pending[key]
+	if !ok {
+		end := u.QuietHours.EndAfter(s.Clock.Now(), u.Location())
+		digest = store.Job{ChatID: u.ChatID, Kind: kindDigest, EventStart: end, Due: end, Text: DigestStr}
+	}
+	line := fmt.Sprintf(DigestLineStr, j.Title, j.EventStart.In(u.Location()).Format("15:04"))
+	if strings.Contains(digest.Text, line) {
+		return // Another alarm of the same event
+	}
+	digest.Text = strings.Join([]string{digest.Text, line}, "\n")
+	if err := s.st.PutJob(digest); err != nil {
+		log.Printf("Error saving alarm job: %s", err)
+		return
+	}
+	if ok {
+		s.pending[key] = digest
+	} else {
+		s.push(digest)
+	}
+}
+
+// deliver posts a job once, it stays pending while being sent so no other alarm of the same lead
+// time is queued meanwhile. It is claimed before posting and its result is handled by finish.
+func (s *AlarmScheduler) deliver(j store.Job, now time.Time) {
+	key := alarmKey{j.ChatID, j.Kind, j.AlarmTimer}
+	j.ClaimedAt = now
+	if err := s.st.PutJob(j); err != nil {
+		log.Printf("Error saving alarm job: %s", err)
+		delete(s.pending, key)
+		return
+	}
+	s.pending[key] = j
+	text := j.Text
+	if j.Kind != kindDigest {
+		// Rendered now, an overdue alarm tells the time actually left
+		text = AlarmText(j, now)
+	}
+	msg := tgbotapi.NewMessage(j.ChatID, text)
+	msg.ParseMode = tgbotapi.ModeMarkdown
+	s.bot.Post(msg, func(_ tgbotapi.Message, err error) {
+		s.mu.Lock()
+		s.sent = append(s.sent, sendResult{j, err})
+		s.mu.Unlock()
+		s.notify()
+	})
+}
+
+// finish deletes a sent job and queues the next alarm. Failed sends are queued again for
+// alarmRetryDelay later unless Telegram refused the chat. A job replaced while being sent, by the
+// alarm of an earlier event, is not retried.
+func (s *AlarmScheduler) finish(j store.Job, err error) {
+	key := alarmKey{j.ChatID, j.Kind, j.AlarmTimer}
+	pending, ok := s.pending[key]
+	if !ok || pending.ID() != j.ID() {
+		s.deleteJob(j)
+		return
+	}
+	if err == nil {
+		s.deleteJob(j)
+		delete(s.pending, key)
+		s.scheduleUserKind(j)
+		return
+	}
+	log.Printf("Error sending message to Chat ID %d: %s", j.ChatID, err)
+	j.ClaimedAt = time.Time{}
+	j.Attempts++
+	j.Due = s.Clock.Now().Add(alarmRetryDelay)
+	var tgErr *tgbotapi.Error
+	if j.Attempts >= maxAlarmAttempts || errors.As(err, &tgErr) && tgErr.Code == 403 {
+		s.deleteJob(j)
+		delete(s.pending, key)
+		s.scheduleUserKind(j)
+		return
+	}
+	if err := s.st.PutJob(j); err != nil {
+		log.Printf("Error saving alarm job: %s", err)
+		delete(s.pending, key)
+		return
+	}
+	s.push(j)
+}
+
+// scheduleUserKind queues the alarm following job j of the user, who is loaded again as their
+// settings may have changed while j was being sent.
+func (s *AlarmScheduler) scheduleUserKind(j store.Job) {
+	u, err := s.st.GetUser(j.ChatID)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Printf("Error loading user %d: %s", j.ChatID, err)
+		}
+		return
+	}
+	s.scheduleNext(u, j)
+}
+
+// scheduleNext queues the user's alarm of the same lead time as job j for the next event.
+func (s *AlarmScheduler) scheduleNext(u store.User, j store.Job) {
+	for _, k := range s.kinds {
+		if k.Schedule.Kind() == j.Kind {
+			s.scheduleLead(u, k, j.AlarmTimer)
+		}
+	}
+}
+
+func (s *AlarmScheduler) deleteJob(j store.Job) {
+	if err := s.st.DeleteJob(j.ID()); err != nil {
+		log.Printf("Error deleting alarm job: %s", err)
+	}
+}
+
+// jobQueue is a min-heap of jobs by due time.
+type jobQueue []store.Job
+
+func (q jobQueue) Len() int           { return len(q) }
+func (q jobQueue) Less(i, j int) bool { return q[i].Due.Before(q[j].Due) }
+func (q jobQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *jobQueue) Push(x any) {
+	*q = append(*q, x.(store.Job))
+}
+
+func (q *jobQueue) Pop() any {
+	old := *q
+	j := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return j
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tetra5/diabler/pkg/clock"
+	"github.com/tetra5/diabler/pkg/d4/events"
+	"github.com/tetra5/diabler/pkg/store"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Legions start at 12:15, 12:40 and 13:05 after this, see rules/season1.json.
+var alarmTestStart = time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)
+
+// fakeBot records the messages sent and fails the ones errs holds, in order. Messages posted to
+// the slow chat wait in held until released.
+type fakeBot struct {
+	mu   sync.Mutex
+	sent []tgbotapi.MessageConfig
+	errs []error
+	slow int64
+	held []func()
+}
+
+func (b *fakeBot) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.errs) > 0 {
+		err := b.errs[0]
+		b.errs = b.errs[1:]
+		return tgbotapi.Message{}, err
+	}
+	if msg, ok := c.(tgbotapi.MessageConfig); ok {
+		b.sent = append(b.sent, msg)
+	}
+	return tgbotapi.Message{MessageID: len(b.sent)}, nil
+}
+
+// Post sends right away, like an outbox without flood limits, unless c goes to the slow chat.
+func (b *fakeBot) Post(c tgbotapi.Chattable, done func(msg tgbotapi.Message, err error)) {
+	if msg, ok := c.(tgbotapi.MessageConfig); ok && b.slow != 0 && msg.ChatID == b.slow {
+		b.mu.Lock()
+		b.held = append(b.held, func() { done(b.Send(c)) })
+		b.mu.Unlock()
+		return
+	}
+	done(b.Send(c))
+}
+
+func (b *fakeBot) release() {
+	b.mu.Lock()
+	held := b.held
+	b.held = nil
+	b.mu.Unlock()
+	for _, send := range held {
+		send()
+	}
+}
+
+func (b *fakeBot) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	return &tgbotapi.APIResponse{Ok: true}, nil
+}
+
+func (b *fakeBot) texts() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var texts []string
+	for _, msg := range b.sent {
+		texts = append(texts, msg.Text)
+	}
+	return texts
+}
+
+type alarmTest struct {
+	t     *testing.T
+	clock *clock.Fake
+	st    *store.Memory
+	bot   *fakeBot
+	wbs   *events.WorldBossSchedule
+	kinds []EventKind
+}
+
+// newAlarmTest makes a store holding the given users and a scheduler setup on a fake clock
+// starting at alarmTestStart.
+func newAlarmTest(t *testing.T, users ...store.User) *alarmTest {
+	fc := clock.NewFake(alarmTestStart)
+	at := &alarmTest{t: t, clock: fc, st: store.NewMemory(), bot: &fakeBot{}, wbs: events.NewWorldBossSchedule()}
+	at.wbs.Clock = fc
+	at.kinds = NewEventKinds(at.wbs, events.NewHelltideSchedule(), events.NewLegionSchedule())
+	for _, u := range users {
+		if err := at.st.PutUser(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return at
+}
+
+// start makes a scheduler the way Run does it on start-up.
+func (at *alarmTest) start() *AlarmScheduler {
+	s := NewAlarmScheduler(at.st, at.kinds, at.bot)
+	s.Clock = at.clock
+	s.rehydrate()
+	return s
+}
+
+func (at *alarmTest) jobs() []store.Job {
+	jobs, err := at.st.Jobs()
+	if err != nil {
+		at.t.Fatal(err)
+	}
+	return jobs
+}
+
+// advanceTo moves the clock to the given UTC time of the test day and delivers due jobs.
+func (at *alarmTest) advanceTo(s *AlarmScheduler, hm string) {
+	at.t.Helper()
+	t, err := time.Parse("15:04:05", hm)
+	if err != nil {
+		at.t.Fatal(err)
+	}
+	at.clock.Set(alarmTestStart.Truncate(24 * time.Hour).Add(time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second))
+	deliverDue(s)
+}
+
+// deliverDue sends due jobs and handles the results the way Run does.
+func deliverDue(s *AlarmScheduler) {
+	s.deliverDue()
+	s.applyChanges()
+}
+
+func legionUser(chatID int64, alarms ...int) store.User {
+	u := store.NewUser(chatID)
+	u.LGAlarms = alarms
+	return u
+}
+
+func TestAlarmsSentAndRescheduled(t *testing.T) {
+	at := newAlarmTest(t, legionUser(1, 10))
+	s := at.start()
+	if jobs := at.jobs(); len(jobs) != 1 || !jobs[0].Due.Equal(time.Date(2023, 7, 1, 12, 5, 0, 0, time.UTC)) {
+		t.Fatalf("jobs on start-up = %v, want one due at 12:05", jobs)
+	}
+	at.advanceTo(s, "12:04:59")
+	if texts := at.bot.texts(); len(texts) != 0 {
+		t.Fatalf("sent before due: %q", texts)
+	}
+	at.advanceTo(s, "12:05:00")
+	if texts := at.bot.texts(); len(texts) != 1 || !strings.Contains(texts[0], "10 minutes") {
+		t.Fatalf("sent at 12:05 = %q, want a 10 minutes alarm", texts)
+	}
+	if jobs := at.jobs(); len(jobs) != 1 || !jobs[0].EventStart.Equal(time.Date(2023, 7, 1, 12, 40, 0, 0, time.UTC)) {
+		t.Errorf("jobs after sending = %v, want the alarm of the 12:40 Legion", jobs)
+	}
+}
+
+// TestAlarmsRun drives the scheduler loop itself, it has to wake up for each job at its due time.
+func TestAlarmsRun(t *testing.T) {
+	at := newAlarmTest(t, legionUser(1, 10))
+	s := NewAlarmScheduler(at.st, at.kinds, at.bot)
+	s.Clock = at.clock
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	// The loop may not be waiting yet when the clock moves, so the clock is moved in small steps
+	// until the alarm is sent.
+	waitSent := func(n int, until time.Time) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for len(at.bot.texts()) < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("sent = %q by %s, want %d alarms", at.bot.texts(), at.clock.Now(), n)
+			}
+			if at.clock.Now().Before(until) {
+				at.clock.Advance(time.Second)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	at.clock.Set(time.Date(2023, 7, 1, 12, 4, 0, 0, time.UTC))
+	waitSent(1, time.Date(2023, 7, 1, 12, 6, 0, 0, time.UTC))
+	at.clock.Set(time.Date(2023, 7, 1, 12, 29, 0, 0, time.UTC))
+	waitSent(2, time.Date(2023, 7, 1, 12, 31, 0, 0, time.UTC))
+	for _, text := range at.bot.texts() {
+		if !strings.Contains(text, "10 minutes") {
+			t.Errorf("sent %q, want 10 minutes alarms", text)
+		}
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return after cancel")
+	}
+}
+
+func TestAlarmsRehydrate(t *testing.T) {
+	at := newAlarmTest(t, legionUser(1, 10))
+	at.start()
+	before := at.jobs()
+
+	// A restart picks up the stored job instead of queueing another one
+	s := at.start()
+	if jobs := at.jobs(); len(jobs) != 1 || jobs[0].ID() != before[0].ID() {
+		t.Fatalf("jobs after restart = %v, want %v", jobs, before)
+	}
+	at.advanceTo(s, "12:05:00")
+	if texts := at.bot.texts(); len(texts) != 1 {
+		t.Errorf("sent after restart = %q, want one alarm", texts)
+	}
+}
+
+func TestAlarmsDropClaimedJobs(t *testing.T) {
+	at := newAlarmTest(t, legionUser(1, 10))
+	at.start()
+	// The process died while sending the 12:05 alarm
+	at.clock.Set(time.Date(2023, 7, 1, 12, 5, 0, 0, time.UTC))
+	claimed := at.jobs()[0]
+	claimed.ClaimedAt = at.clock.Now()
+	if err := at.st.PutJob(claimed); err != nil {
+		t.Fatal(err)
+	}
+	at.clock.Advance(time.Minute)
+	s := at.start()
+	deliverDue(s)
+	if texts := at.bot.texts(); len(texts) != 0 {
+		t.Errorf("claimed alarm was sent again: %q", texts)
+	}
+	if jobs := at.jobs(); len(jobs) != 1 || jobs[0].ID() == claimed.ID() {
+		t.Errorf("jobs after restart = %v, want only the alarm of the next Legion", jobs)
+	}
+}
+
+func TestAlarmsOverdue(t *testing.T) {
+	// Back before the event starts, the alarm is sent late and tells the time actually left
+	at := newAlarmTest(t, legionUser(1, 10))
+	at.start()
+	at.clock.Set(time.Date(2023, 7, 1, 12, 10, 0, 0, time.UTC))
+	s := at.start()
+	deliverDue(s)
+	if texts := at.bot.texts(); len(texts) != 1 || !strings.Contains(texts[0], "5 minutes") {
+		t.Errorf("overdue alarm = %q, want one telling 5 minutes", texts)
+	}
+
+	// Back after the event started, the alarm is dropped
+	at = newAlarmTest(t, legionUser(1, 10))
+	at.start()
+	at.clock.Set(time.Date(2023, 7, 1, 12, 20, 0, 0, time.UTC))
+	s = at.start()
+	deliverDue(s)
+	if texts := at.bot.texts(); len(texts) != 0 {
+		t.Errorf("alarm of a started event was sent: %q", texts)
+	}
+	if jobs := at.jobs(); len(jobs) != 1 || !jobs[0].EventStart.Equal(time.Date(2023, 7, 1, 12, 40, 0, 0, time.UTC)) {
+		t.Errorf("jobs after dropping = %v, want the alarm of the 12:40 Legion", jobs)
+	}
+}
+
+func TestAlarmsReplan(t *testing.T) {
+	at := newAlarmTest(t, legionUser(1, 10))
+	s := at.start()
+	s.Replan(events.KindLegion)
+	s.applyChanges()
+	// The replaced job is still in the heap, it must not be sent along with its replacement
+	at.advanceTo(s, "12:05:00")
+	if texts := at.bot.texts(); len(texts) != 1 {
+		t.Errorf("sent after Replan = %q, want one alarm", texts)
+	}
+	if jobs := at.jobs(); len(jobs) != 1 {
+		t.Errorf("jobs after Replan = %v, want one", jobs)
+	}
+}
+
+func TestAlarmsUnmute(t *testing.T) {
+	u := store.NewUser(1)
+	u.WBAlarms = []int{10}
+	at := newAlarmTest(t)
+	first := at.wbs.NextAt(alarmTestStart.Add(10 * time.Minute)) // Its alarm is yet to go off
+	u.WBMutedBosses = []string{first.Name}
+	if err := at.st.PutUser(u); err != nil {
+		t.Fatal(err)
+	}
+	s := at.start()
+	later := at.jobs()
+	if len(later) != 1 || !later[0].EventStart.After(first.SpawnTime) {
+		t.Fatalf("jobs with %s muted = %v, want one for a later boss", first.Name, later)
+	}
+
+	_, err := at.st.UpdateUser(1, func(u *store.User) error {
+		u.WBMutedBosses = nil
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Reschedule(1)
+	s.applyChanges()
+	if jobs := at.jobs(); len(jobs) != 1 || !jobs[0].EventStart.Equal(first.SpawnTime) {
+		t.Fatalf("jobs after unmuting = %v, want only the one of %s", jobs, first.Name)
+	}
+	for at.clock.Set(first.SpawnTime.Add(-10 * time.Minute)); at.clock.Now().Before(later[0].EventStart); at.clock.Advance(time.Minute) {
+		deliverDue(s)
+	}
+	// The job of the later boss, superseded while its replacement was queued, is not sent twice
+	texts := at.bot.texts()
+	if len(texts) == 0 || !strings.Contains(texts[0], first.Name) {
+		t.Fatalf("sent = %q, want the alarm of %s first", texts, first.Name)
+	}
+	if n := strings.Count(strings.Join(texts, "\n"), later[0].Title); n != 1 {
+		t.Errorf("sent = %q, want the alarm of %s once", texts, later[0].Title)
+	}
+}
+
+// TestAlarmsSlowChat checks a chat whose alarm is still being sent holds back neither the other
+// chats nor the scheduler, and gets no alarm twice.
+func TestAlarmsSlowChat(t *testing.T) {
+	at := newAlarmTest(t, legionUser(1, 10), legionUser(2, 10))
+	at.bot.slow = 1
+	s := at.start()
+	at.advanceTo(s, "12:05:00")
+	if texts := at.bot.texts(); len(texts) != 1 || at.bot.sent[0].ChatID != 2 {
+		t.Fatalf("sent while chat 1 is slow = %q, want the alarm of chat 2", texts)
+	}
+	// Neither a settings change nor a Replan queues another alarm while the first one is sent
+	s.Reschedule(1)
+	s.Replan(events.KindLegion)
+	s.applyChanges()
+	at.advanceTo(s, "12:05:30")
+	at.bot.slow = 0
+	at.bot.release()
+	s.applyChanges()
+	at.advanceTo(s, "12:06:00")
+	sent := map[int64]int{}
+	for _, msg := range at.bot.sent {
+		sent[msg.ChatID]++
+	}
+	if sent[1] != 1 || sent[2] != 1 {
+		t.Errorf("alarms sent to each chat = %v, want one", sent)
+	}
+	for _, j := range at.jobs() {
+		if !j.EventStart.Equal(time.Date(2023, 7, 1, 12, 40, 0, 0, time.UTC)) || !j.ClaimedAt.IsZero() {
+			t.Errorf("job %+v left, want only the alarms of the 12:40 Legion", j)
+		}
+	}
+}
+
+func TestAlarmsRetry(t *testing.T) {
+	at := newAlarmTest(t, legionUser(1, 10))
+	at.bot.errs = []error{errors.New("connection reset"), errors.New("connection reset")}
+	s := at.start()
+	at.advanceTo(s, "12:05:00")
+	at.advanceTo(s, "12:05:30")
+	if texts := at.bot.texts(); len(texts) != 0 {
+		t.Fatalf("sent = %q, want none yet", texts)
+	}
+	at.advanceTo(s, "12:06:00")
+	if texts := at.bot.texts(); len(texts) != 1 || !strings.Contains(texts[0], "9 minutes") {
+		t.Errorf("sent on the third attempt = %q, want a 9 minutes alarm", texts)
+	}
+
+	// Blocked chats are not retried
+	at = newAlarmTest(t, legionUser(1, 10))
+	at.bot.errs = []error{&tgbotapi.Error{Code: 403, Message: "Forbidden: bot was blocked by the user"}}
+	s = at.start()
+	at.advanceTo(s, "12:05:00")
+	at.advanceTo(s, "12:05:30")
+	if texts := at.bot.texts(); len(texts) != 0 {
+		t.Errorf("sent to a blocked chat = %q", texts)
+	}
+	for _, j := range at.jobs() {
+		if j.Attempts > 0 {
+			t.Errorf("job for a blocked chat is retried: %v", j)
+		}
+	}
+}
+
+func TestAlarmsQuietDigest(t *testing.T) {
+	u := legionUser(1, 10)
+	u.QuietHours = &events.TimeWindow{Start: 11 * time.Hour, End: 13 * time.Hour}
+	u.QuietDigest = true
+	at := newAlarmTest(t, u)
+	s := at.start()
+	for _, hm := range []string{"12:05:00", "12:30:00", "12:55:00"} {
+		at.advanceTo(s, hm)
+	}
+	if texts := at.bot.texts(); len(texts) != 0 {
+		t.Fatalf("sent during quiet hours = %q", texts)
+	}
+	at.advanceTo(s, "13:00:00")
+	texts := at.bot.texts()
+	if len(texts) != 1 {
+		t.Fatalf("sent after quiet hours = %q, want one digest", texts)
+	}
+	for _, start := range []string{"12:15", "12:40", "13:05"} {
+		if !strings.Contains(texts[0], start) {
+			t.Errorf("digest %q misses the %s Legion", texts[0], start)
+		}
+	}
+
+	// Without a digest alarms in quiet hours are just dropped
+	u.QuietDigest = false
+	at = newAlarmTest(t, u)
+	s = at.start()
+	for _, hm := range []string{"12:05:00", "12:30:00", "12:55:00"} {
+		at.advanceTo(s, hm)
+	}
+	if texts := at.bot.texts(); len(texts) != 0 {
+		t.Errorf("sent = %q, want nothing", texts)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tetra5/diabler/pkg/d4/events"
+	"github.com/tetra5/diabler/pkg/menu"
+	"github.com/tetra5/diabler/pkg/store"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// bossesCallback is the World Boss subscriptions menu, its buttons toggle a boss by ID.
+const bossesCallback = "diabler-settings-bosses"
+
+// UpdateBossSubscriptions applies a subscriptions menu action to the user, reports if anything
+// changed. Bosses are stored by name so subscriptions outlive rule set changes.
+func UpdateBossSubscriptions(u *store.User, rules *events.RuleSet, p menu.Payload) (changed bool) {
+	if p.Action != "toggle" {
+		return false
+	}
+	id, err := p.Int(0)
+	if err != nil {
+		return false
+	}
+	name, ok := rules.Bosses[id]
+	if !ok {
+		return false
+	}
+	var muted []string // A new slice, the old one may be shared with the store
+	for _, m := range u.WBMutedBosses {
+		if !strings.EqualFold(m, name) {
+			muted = append(muted, m)
+		}
+	}
+	if len(muted) == len(u.WBMutedBosses) {
+		muted = append(muted, name)
+	}
+	u.WBMutedBosses = muted
+	return true
+}
+
+// BossesAnswer renders the callback query answer for a subscriptions change.
+func BossesAnswer(u *store.User, rules *events.RuleSet, p menu.Payload) string {
+	id, _ := p.Int(0)
+	name := rules.Bosses[id]
+	if u.MutesBoss(name) {
+		return fmt.Sprintf(BossMutedStr, name)
+	}
+	return fmt.Sprintf(BossSubscribedStr, name)
+}
+
+// BossesMenuText renders the World Boss subscriptions menu.
+func BossesMenuText(u *store.User, rules *events.RuleSet) string {
+	var subscribed []string
+	for _, id := range bossIDs(rules) {
+		if !u.MutesBoss(rules.Bosses[id]) {
+			subscribed = append(subscribed, rules.Bosses[id])
+		}
+	}
+	text := BossesNoneStr
+	if len(subscribed) > 0 {
+		text = strings.Join(subscribed, ", ")
+	}
+	return strings.Join([]string{SettingsMenuBossesStr, fmt.Sprintf(BossesStr, text)}, "\n")
+}
+
+// BossesMenuMarkup renders a checkbox button for every boss of the rule set.
+func BossesMenuMarkup(u *store.User, rules *events.RuleSet) *tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, id := range bossIDs(rules) {
+		name := rules.Bosses[id]
+		check := "✅"
+		if u.MutesBoss(name) {
+			check = "⬜"
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(menu.Button(fmt.Sprintf("%s %s", check, name),
+			menu.Payload{Screen: bossesCallback, Action: "toggle", Args: []any{id}})))
+	}
+	rows = append(rows, backRows...)
+	markup := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return &markup
+}
+
+func bossIDs(rules *events.RuleSet) []int {
+	ids := make([]int, 0, len(rules.Bosses))
+	for id := range rules.Bosses {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tetra5/diabler/pkg/d4/events"
+)
+
+// Chat IDs allowed to report spawns, from the ADMIN_CHAT_IDS env var. Reports re-anchor the schedule
+// of every chat, so nobody may report if it is empty.
+var admins = map[int64]bool{}
+
+func ParseAdmins(s string) (err error) {
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		chatID, errParse := strconv.ParseInt(field, 10, 64)
+		if errParse != nil {
+			err = errors.Join(err, errParse)
+			continue
+		}
+		admins[chatID] = true
+	}
+	return err
+}
+
+func CanReportSpawns(chatID int64) bool {
+	return admins[chatID]
+}
+
+// ParseSpawnReport parses "/spawned" command arguments: a boss name, optionally followed by the
+// local "HH:MM" spawn time. Without a time the boss is considered to have spawned just now.
+func ParseSpawnReport(args string, rules *events.RuleSet, now time.Time, loc *time.Location) (o events.Observation, err error) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return o, errors.New("boss name is missing")
+	}
+	o.SpawnTime = now.UTC().Truncate(time.Minute)
+	if hm, errParse := time.Parse("15:04", fields[len(fields)-1]); errParse == nil {
+		fields = fields[:len(fields)-1]
+		local := now.In(loc)
+		t := time.Date(local.Year(), local.Month(), local.Day(), hm.Hour(), hm.Minute(), 0, 0, loc)
+		if t.After(now) {
+			t = t.AddDate(0, 0, -1) // Reports are about the past, so it must have been yesterday
+		}
+		o.SpawnTime = t.UTC()
+	}
+	name, ok := rules.BossName(strings.Join(fields, " "))
+	if !ok {
+		return o, fmt.Errorf("unknown boss %q", strings.Join(fields, " "))
+	}
+	o.Boss = name
+	o.ReportedAt = now.UTC()
+	return o, nil
+}
+
+// Calibrate fits the schedule to every spawn report, it logs and returns the reports it had to
+// skip.
+func Calibrate(wbs *events.WorldBossSchedule, obs []events.Observation) []*events.RejectedReport {
+	if len(obs) == 0 {
+		return nil
+	}
+	err := wbs.Calibrate(obs)
+	if err != nil {
+		log.Printf("Calibration: %s", err)
+	}
+	log.Printf("Calibration: based on %s", PluralizeStr(len(wbs.BasedOn()), "report", "reports", true))
+	return events.RejectedReports(err)
+}
+
+// sameReport tells whether a and b are the same spawn report.
+func sameReport(a events.Observation, b events.Observation) bool {
+	return a.Boss == b.Boss && a.SpawnTime.Equal(b.SpawnTime) && a.ReportedBy == b.ReportedBy && a.ReportedAt.Equal(b.ReportedAt)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tetra5/diabler/pkg/d4/events"
+	"github.com/tetra5/diabler/pkg/menu"
+	"github.com/tetra5/diabler/pkg/store"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// EventKind ties an event schedule to its menu entry and alarm settings. Adding a new event
+// type only takes a new EventKind and a store.User field for its alarms.
+type EventKind struct {
+	Schedule      events.Schedule
+	Label         string // "World Boss", "Helltide", ...
+	Callback      string // Main menu entry
+	AlarmCallback string // Alarm settings menu screen
+	AlarmSteps    []int  // Minutes a new alarm lead time is adjusted by in the settings menu
+	MaxAlarmTimer int    // Minutes
+}
+
+func NewEventKinds(wbs *events.WorldBossSchedule, hts *events.HelltideSchedule, ls *events.LegionSchedule) []EventKind {
+	return []EventKind{
+		{
+			Schedule:      wbs,
+			Label:         "World Boss",
+			Callback:      "diabler-wb",
+			AlarmCallback: "diabler-settings-alarm",
+			AlarmSteps:    []int{30, 5, 1},
+			MaxAlarmTimer: maxWBAlarmTimer,
+		},
+		{
+			Schedule:      hts,
+			Label:         "Helltide",
+			Callback:      "diabler-helltide",
+			AlarmCallback: "diabler-settings-ht-alarm",
+			AlarmSteps:    []int{30, 5, 1},
+			MaxAlarmTimer: maxHTAlarmTimer,
+		},
+		{
+			Schedule:      ls,
+			Label:         "Legion",
+			Callback:      "diabler-legion",
+			AlarmCallback: "diabler-settings-lg-alarm",
+			AlarmSteps:    []int{5, 1},
+			MaxAlarmTimer: maxLGAlarmTimer,
+		},
+	}
+}
+
+// AlarmDraft is the lead time to offer for a new alarm. The alarm settings menu carries it in
+// its buttons.
+func (k EventKind) AlarmDraft(p menu.Payload) int {
+	draft := defaultAlarmTimer
+	if p.Action == "draft" || p.Action == "add" {
+		if n, err := p.Int(0); err == nil {
+			draft = n
+		}
+	}
+	if draft < 0 {
+		return 0
+	} else if draft > k.MaxAlarmTimer {
+		return k.MaxAlarmTimer
+	}
+	return draft
+}
+
+// UpdateAlarm applies an alarm settings menu action to the user, reports if anything changed.
+func (k EventKind) UpdateAlarm(u *store.User, p menu.Payload) (changed bool) {
+	alarms := u.Alarms(k.Schedule.Kind())
+	switch p.Action {
+	case "disable":
+		changed = len(*alarms) > 0
+		*alarms = nil
+	case "add":
+		draft := k.AlarmDraft(p)
+		if len(*alarms) < store.MaxAlarms && !u.HasAlarm(k.Schedule.Kind(), draft) {
+			*alarms = append(append([]int(nil), *alarms...), draft)
+			sort.Sort(sort.Reverse(sort.IntSlice(*alarms)))
+			changed = true
+		}
+	case "remove":
+		lead, err := p.Int(0)
+		if err != nil {
+			return false
+		}
+		var kept []int // A new slice, the old one may be shared with the store
+		for _, l := range *alarms {
+			if l != lead {
+				kept = append(kept, l)
+			}
+		}
+		changed = len(kept) != len(*alarms)
+		*alarms = kept
+	}
+	return changed
+}
+
+// AlarmAnswer renders the callback query answer for an alarm settings change.
+func (k EventKind) AlarmAnswer(p menu.Payload) string {
+	switch p.Action {
+	case "add":
+		return fmt.Sprintf(AlarmAddedStr, k.Label, FormatAlarmTimer(k.AlarmDraft(p)))
+	case "remove":
+		lead, _ := p.Int(0)
+		return fmt.Sprintf(AlarmRemovedStr, k.Label, FormatAlarmTimer(lead))
+	case "disable":
+		return fmt.Sprintf(AlarmsDisabledStr, k.Label)
+	}
+	return ""
+}
+
+// TimerMenuLine renders a line describing the user's alarms for this kind.
+func (k EventKind) TimerMenuLine(u *store.User) string {
+	alarms := *u.Alarms(k.Schedule.Kind())
+	if len(alarms) == 0 {
+		return fmt.Sprintf(TimerDisabledMenuStr, k.Label)
+	}
+	return fmt.Sprintf(TimerMenuStr, k.Label, FormatAlarms(alarms))
+}
+
+// AlarmMenuText renders the alarm settings menu for this kind.
+func (k EventKind) AlarmMenuText(u *store.User, draft int) string {
+	return strings.Join([]string{
+		fmt.Sprintf(SettingsMenuAlarmStr, k.Label),
+		k.TimerMenuLine(u),
+		fmt.Sprintf(NewAlarmStr, FormatAlarmTimer(draft)),
+	}, "\n")
+}
+
+// AlarmMenuMarkup renders the alarm settings menu buttons: the new alarm lead time adjustment,
+// adding it and removing the existing alarms.
+func (k EventKind) AlarmMenuMarkup(u *store.User, draft int) *tgbotapi.InlineKeyboardMarkup {
+	clamp := func(n int) int {
+		if n < 0 {
+			return 0
+		} else if n > k.MaxAlarmTimer {
+			return k.MaxAlarmTimer
+		}
+		return n
+	}
+	payload := func(action string, minutes int) menu.Payload {
+		return menu.Payload{Screen: k.AlarmCallback, Action: action, Args: []any{minutes}}
+	}
+	var adjustRow []tgbotapi.InlineKeyboardButton
+	for _, step := range k.AlarmSteps {
+		adjustRow = append(adjustRow, menu.Button(fmt.Sprintf("-%d", step), payload("draft", clamp(draft-step))))
+	}
+	for i := len(k.AlarmSteps) - 1; i >= 0; i-- {
+		step := k.AlarmSteps[i]
+		adjustRow = append(adjustRow, menu.Button(fmt.Sprintf("+%d", step), payload("draft", clamp(draft+step))))
+	}
+	rows := [][]tgbotapi.InlineKeyboardButton{adjustRow}
+	alarms := *u.Alarms(k.Schedule.Kind())
+	if len(alarms) < store.MaxAlarms && !u.HasAlarm(k.Schedule.Kind(), draft) {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			menu.Button(fmt.Sprintf("➕ Add %s", FormatAlarmTimer(draft)), payload("add", draft))))
+	}
+	if len(alarms) > 0 {
+		var removeRow []tgbotapi.InlineKeyboardButton
+		for _, lead := range alarms {
+			removeRow = append(removeRow, menu.Button(fmt.Sprintf("➖ %s", FormatAlarmTimer(lead)), payload("remove", lead)))
+		}
+		rows = append(rows, removeRow, tgbotapi.NewInlineKeyboardRow(
+			menu.Button("❌ Disable", menu.Payload{Screen: k.AlarmCallback, Action: "disable"})))
+	}
+	rows = append(rows, backRows...)
+	markup := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return &markup
+}
+
+// FormatAlarmTimer renders an alarm lead time.
+func FormatAlarmTimer(timer int) string {
+	if timer == 0 {
+		return AlarmAtStartStr
+	}
+	return PluralizeStr(timer, "minute", "minutes", true)
+}
+
+// FormatAlarms renders a list of alarm lead times.
+func FormatAlarms(alarms []int) string {
+	strs := make([]string, len(alarms))
+	for i, timer := range alarms {
+		strs[i] = FormatAlarmTimer(timer)
+	}
+	return strings.Join(strs, ", ")
+}
+
+// AlarmText renders the alarm of a job sent at now, with the time actually left to the event.
+func AlarmText(j store.Job, now time.Time) string {
+	left := int(j.EventStart.Sub(now).Round(time.Minute) / time.Minute)
+	if left < 0 {
+		left = 0
+	}
+	text := fmt.Sprintf(AlarmStr, j.Title, FormatAlarmTimer(left))
+	if j.Zone != "" {
+		text = strings.Join([]string{text, fmt.Sprintf(ZoneStr, j.Zone)}, "\n")
+	}
+	return text
+}
+
+// EventText renders the current or next event along with the user's alarm.
+func (k EventKind) EventText(u *store.User, now time.Time) string {
+	loc := u.Location()
+	var text string
+	var ev events.Event
+	if active, ok := k.Schedule.ActiveEventAt(now); ok {
+		ev = active
+		remaining := RoundUpTime(active.End(), time.Minute).Sub(now)
+		text = fmt.Sprintf(EventActiveStr,
+			active.Title(),
+			remaining.Round(time.Second).String(),
+			active.End().In(loc).Format(time.DateTime),
+			FormatZone(active.End().In(loc)),
+		)
+	} else {
+		next := k.Schedule.NextEventAt(now)
+		ev = next
+		remaining := RoundUpTime(next.Start(), time.Minute).Sub(now)
+		text = fmt.Sprintf(EventNextStr,
+			next.Title(),
+			remaining.Round(time.Second).String(),
+			next.Start().In(loc).Format(time.DateTime),
+			FormatZone(next.Start().In(loc)),
+		)
+	}
+	if ev.Zone() != "" {
+		text = strings.Join([]string{text, fmt.Sprintf(ZoneStr, ev.Zone())}, "\n")
+	}
+	var timerStr string
+	if alarms := *u.Alarms(k.Schedule.Kind()); len(alarms) > 0 {
+		timerStr = fmt.Sprintf(TimerStr, FormatAlarms(alarms))
+	} else {
+		timerStr = TimerDisabledStr
+	}
+	return strings.Join([]string{text, timerStr}, "\n")
+}
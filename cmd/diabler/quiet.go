@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tetra5/diabler/pkg/d4/events"
+	"github.com/tetra5/diabler/pkg/menu"
+	"github.com/tetra5/diabler/pkg/store"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// quietCallback is the quiet hours menu.
+const quietCallback = "diabler-settings-quiet"
+
+// Quiet hours offered when enabling them, local time.
+var defaultQuietHours = events.TimeWindow{Start: 23 * time.Hour, End: 7 * time.Hour}
+
+// UpdateQuietHours applies a quiet hours menu action to the user, reports if anything changed.
+// Boundaries move by an hour and never meet.
+func UpdateQuietHours(u *store.User, p menu.Payload) (changed bool) {
+	if p.Action == "enable" {
+		w := defaultQuietHours
+		u.QuietHours = &w
+		return true
+	}
+	if u.QuietHours == nil {
+		return false
+	}
+	w := *u.QuietHours
+	hours, err := p.Int(0)
+	if (p.Action == "start" || p.Action == "end") && err != nil {
+		return false
+	}
+	shift := time.Duration(24+hours%24) * time.Hour
+	switch p.Action {
+	case "disable":
+		u.QuietHours = nil
+		return true
+	case "mode":
+		u.QuietDigest = !u.QuietDigest
+		return true
+	case "start":
+		w.Start = (w.Start + shift) % (24 * time.Hour)
+	case "end":
+		w.End = (w.End + shift) % (24 * time.Hour)
+	default:
+		return false
+	}
+	if w.Start == w.End {
+		return false
+	}
+	u.QuietHours = &w
+	return true
+}
+
+// QuietAnswer renders the callback query answer for a quiet hours change.
+func QuietAnswer(u *store.User) string {
+	if u.QuietHours == nil {
+		return QuietOffStr
+	}
+	mode := QuietSuppressStr
+	if u.QuietDigest {
+		mode = QuietDigestStr
+	}
+	return fmt.Sprintf(QuietOnStr, u.QuietHours.String(), mode)
+}
+
+// QuietMenuText renders the quiet hours menu.
+func QuietMenuText(u *store.User) string {
+	return strings.Join([]string{SettingsMenuQuietStr, QuietMenuLine(u)}, "\n")
+}
+
+// QuietMenuLine renders a line describing the user's quiet hours.
+func QuietMenuLine(u *store.User) string {
+	if u.QuietHours == nil {
+		return QuietDisabledStr
+	}
+	mode := QuietSuppressStr
+	if u.QuietDigest {
+		mode = QuietDigestStr
+	}
+	return fmt.Sprintf(QuietStr, u.QuietHours.String(), mode)
+}
+
+// QuietMenuMarkup renders the quiet hours menu buttons.
+func QuietMenuMarkup(u *store.User) *tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	payload := func(action string, args ...any) menu.Payload {
+		return menu.Payload{Screen: quietCallback, Action: action, Args: args}
+	}
+	if u.QuietHours == nil {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(menu.Button("🌙 Enable", payload("enable"))))
+	} else {
+		mode := "📬 Send a digest instead"
+		if u.QuietDigest {
+			mode = "🔕 Drop alarms instead"
+		}
+		rows = append(rows,
+			tgbotapi.NewInlineKeyboardRow(
+				menu.Button("Start -1 hour", payload("start", -1)),
+				menu.Button("Start +1 hour", payload("start", 1)),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				menu.Button("End -1 hour", payload("end", -1)),
+				menu.Button("End +1 hour", payload("end", 1)),
+			),
+			tgbotapi.NewInlineKeyboardRow(menu.Button(mode, payload("mode"))),
+			tgbotapi.NewInlineKeyboardRow(menu.Button("❌ Disable", payload("disable"))),
+		)
+	}
+	rows = append(rows, backRows...)
+	markup := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return &markup
+}
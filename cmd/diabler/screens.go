@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/tetra5/diabler/pkg/d4/events"
+	"github.com/tetra5/diabler/pkg/menu"
+	"github.com/tetra5/diabler/pkg/store"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// NewRouter registers every menu screen and chat command of the bot.
+func NewRouter(bot menu.Bot, st store.Store, kinds []EventKind, wbs *events.WorldBossSchedule, alarms *AlarmScheduler) *menu.Router {
+	rt := menu.NewRouter(bot)
+	rt.Clock = clk
+	rt.Expired = MenuExpiredStr
+	rt.Unchanged = MenuUnchangedStr
+	rt.Use(menu.ReportErrors(DataSaveErrorStr), loadUser(st), menu.SaveState(func(r *menu.Request) error {
+		if err := st.PutUser(*user(r)); err != nil {
+			return fmt.Errorf("saving user: %w", err)
+		}
+		return nil
+	}))
+	reschedule := func(r *menu.Request) {
+		r.Changed()
+		r.OnSaved(func() { alarms.Reschedule(r.ChatID) })
+	}
+
+	rt.Screen(menu.Screen{
+		Name: "diabler-main",
+		Render: func(r *menu.Request) (string, *tgbotapi.InlineKeyboardMarkup) {
+			return MainMenuStr, &mainMenuMarkup
+		},
+	})
+	rt.Screen(menu.Screen{
+		Name: "diabler-settings",
+		Render: func(r *menu.Request) (string, *tgbotapi.InlineKeyboardMarkup) {
+			return SettingsMenuText(user(r), kinds, r.Now), &settingsMenuMarkup
+		},
+	})
+	for _, k := range kinds {
+		k := k
+		rt.Screen(menu.Screen{
+			Name: k.Callback,
+			Render: func(r *menu.Request) (string, *tgbotapi.InlineKeyboardMarkup) {
+				return k.EventText(user(r), r.Now), nil
+			},
+			NewMessage: true,
+		})
+		rt.Screen(menu.Screen{
+			Name: k.AlarmCallback,
+			Handle: func(r *menu.Request) error {
+				if k.UpdateAlarm(user(r), r.Payload) {
+					reschedule(r)
+					r.Answer = k.AlarmAnswer(r.Payload)
+				}
+				switch draft := k.AlarmDraft(r.Payload); {
+				case r.Payload.Action != "draft":
+				case draft == 0:
+					r.Unchanged = AlarmMinimumStr
+				case draft == k.MaxAlarmTimer:
+					r.Unchanged = AlarmMaximumStr
+				}
+				return nil
+			},
+			Render: func(r *menu.Request) (string, *tgbotapi.InlineKeyboardMarkup) {
+				draft := k.AlarmDraft(r.Payload)
+				return k.AlarmMenuText(user(r), draft), k.AlarmMenuMarkup(user(r), draft)
+			},
+		})
+	}
+	rt.Screen(menu.Screen{
+		Name: bossesCallback,
+		Handle: func(r *menu.Request) error {
+			if UpdateBossSubscriptions(user(r), wbs.RuleSet(), r.Payload) {
+				reschedule(r)
+				r.Answer = BossesAnswer(user(r), wbs.RuleSet(), r.Payload)
+			}
+			return nil
+		},
+		Render: func(r *menu.Request) (string, *tgbotapi.InlineKeyboardMarkup) {
+			return BossesMenuText(user(r), wbs.RuleSet()), BossesMenuMarkup(user(r), wbs.RuleSet())
+		},
+	})
+	rt.Screen(menu.Screen{
+		Name: quietCallback,
+		Handle: func(r *menu.Request) error {
+			if UpdateQuietHours(user(r), r.Payload) {
+				r.Changed()
+				r.Answer = QuietAnswer(user(r))
+			} else if r.Payload.Action == "start" || r.Payload.Action == "end" {
+				r.Unchanged = QuietBoundsStr
+			}
+			return nil
+		},
+		Render: func(r *menu.Request) (string, *tgbotapi.InlineKeyboardMarkup) {
+			return QuietMenuText(user(r)), QuietMenuMarkup(user(r))
+		},
+	})
+	rt.Screen(menu.Screen{
+		Name: tzCallback,
+		Handle: func(r *menu.Request) error {
+			if UpdateTimeZone(user(r), r.Payload) {
+				r.Changed()
+				r.Answer = TimeZoneAnswer(user(r), r.Now)
+			}
+			return nil
+		},
+		Render: func(r *menu.Request) (string, *tgbotapi.InlineKeyboardMarkup) {
+			return TimeZoneMenuText(user(r), r.Now), TimeZoneMenuMarkup(TimeZoneRegion(r.Payload), r.Now)
+		},
+	})
+
+	// The menu is an ordinary chat message which is edited on every button press.
+	rt.Command(menu.Command{
+		Name: "diabler",
+		Handle: func(r *menu.Request) error {
+			r.Text, r.Markup = MainMenuStr, &mainMenuMarkup
+			return nil
+		},
+	})
+	rt.Command(menu.Command{
+		Name: "spawned",
+		Handle: func(r *menu.Request) error {
+			if !CanReportSpawns(r.ChatID) {
+				r.Text = SpawnReportForbiddenStr
+				return nil
+			}
+			o, err := ParseSpawnReport(r.Args, wbs.RuleSet(), r.Now, user(r).Location())
+			if err != nil {
+				r.Text = fmt.Sprintf(SpawnReportUsageStr, err)
+				return nil
+			}
+			o.ReportedBy = r.ChatID
+			observations, err := st.Observations()
+			if err != nil {
+				return fmt.Errorf("loading observations: %w", err)
+			}
+			// A report calibration rejects is not saved, it would only be rejected again
+			for _, rejected := range Calibrate(wbs, append(observations, o)) {
+				if sameReport(rejected.Observation, o) {
+					r.Text = fmt.Sprintf(SpawnReportRejectedStr, rejected)
+					return nil
+				}
+			}
+			if err := st.AddObservation(o); err != nil {
+				Calibrate(wbs, observations)
+				return fmt.Errorf("saving observation: %w", err)
+			}
+			alarms.Replan(events.KindWorldBoss)
+			next := wbs.Next()
+			local := next.SpawnTime.In(user(r).Location())
+			r.Text = fmt.Sprintf(SpawnReportStr,
+				PluralizeStr(len(wbs.BasedOn()), "report", "reports", true),
+				next.Name,
+				local.Format(time.DateTime),
+				FormatZone(local),
+			)
+			return nil
+		},
+	})
+	rt.Command(menu.Command{
+		Name: "tz",
+		Handle: func(r *menu.Request) error {
+			name := strings.TrimSpace(r.Args)
+			if name == "" {
+				r.Text = strings.Join([]string{TimeZoneMenuLine(user(r), r.Now), TimeZoneUsageStr}, "\n")
+				return nil
+			}
+			changed, err := SetTimeZone(user(r), name)
+			if err != nil {
+				r.Text = fmt.Sprintf(TimeZoneUnknownStr, name)
+				return nil
+			}
+			if changed {
+				r.Changed()
+			}
+			r.Text = TimeZoneMenuLine(user(r), r.Now)
+			return nil
+		},
+	})
+	return rt
+}
+
+// loadUser puts the requesting user into r.State, unknown chats get a new user.
+func loadUser(st store.Store) menu.Middleware {
+	return func(next menu.Handler) menu.Handler {
+		return func(r *menu.Request) error {
+			u, err := st.GetUser(r.ChatID)
+			if errors.Is(err, store.ErrNotFound) {
+				log.Printf("User %d not found. I make a new one!", r.ChatID)
+				u = store.NewUser(r.ChatID)
+				if err := st.PutUser(u); err != nil {
+					return err
+				}
+			} else if err != nil {
+				return fmt.Errorf("loading user: %w", err)
+			}
+			r.State = &u
+			return next(r)
+		}
+	}
+}
+
+// user returns the requesting user loaded by loadUser.
+func user(r *menu.Request) *store.User {
+	return r.State.(*store.User)
+}
+
+// SettingsMenuText renders the settings menu: the time zone and a line for every setting.
+func SettingsMenuText(u *store.User, kinds []EventKind, now time.Time) string {
+	textLines := []string{
+		SettingsMenuStr,
+		TimeZoneMenuLine(u, now),
+	}
+	for _, k := range kinds {
+		textLines = append(textLines, k.TimerMenuLine(u))
+	}
+	textLines = append(textLines, QuietMenuLine(u))
+	return strings.Join(textLines, "\n")
+}
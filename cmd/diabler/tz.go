@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tetra5/diabler/pkg/menu"
+	"github.com/tetra5/diabler/pkg/store"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// tzCallback is the time zone picker, its buttons either list the zones of a region or set one.
+const tzCallback = "diabler-settings-tz"
+
+// tzRegions lists the zones offered by the picker, any other IANA zone can be set with /tz.
+var tzRegions = []struct {
+	Name  string
+	Zones []string
+}{
+	{"Europe", []string{
+		"Europe/London", "Europe/Dublin", "Europe/Lisbon", "Europe/Paris", "Europe/Berlin",
+		"Europe/Madrid", "Europe/Rome", "Europe/Amsterdam", "Europe/Stockholm", "Europe/Warsaw",
+		"Europe/Prague", "Europe/Athens", "Europe/Helsinki", "Europe/Kyiv", "Europe/Istanbul",
+		"Europe/Moscow",
+	}},
+	{"America", []string{
+		"America/St_Johns", "America/Halifax", "America/New_York", "America/Toronto",
+		"America/Chicago", "America/Mexico_City", "America/Denver", "America/Phoenix",
+		"America/Los_Angeles", "America/Vancouver", "America/Anchorage", "America/Bogota",
+		"America/Lima", "America/Santiago", "America/Sao_Paulo", "America/Argentina/Buenos_Aires",
+	}},
+	{"Asia", []string{
+		"Asia/Dubai", "Asia/Tehran", "Asia/Karachi", "Asia/Kolkata", "Asia/Kathmandu",
+		"Asia/Dhaka", "Asia/Bangkok", "Asia/Jakarta", "Asia/Singapore", "Asia/Shanghai",
+		"Asia/Hong_Kong", "Asia/Taipei", "Asia/Manila", "Asia/Seoul", "Asia/Tokyo",
+		"Asia/Yekaterinburg", "Asia/Novosibirsk", "Asia/Vladivostok",
+	}},
+	{"Africa", []string{
+		"Africa/Casablanca", "Africa/Lagos", "Africa/Cairo", "Africa/Johannesburg", "Africa/Nairobi",
+	}},
+	{"Australia", []string{
+		"Australia/Perth", "Australia/Darwin", "Australia/Adelaide", "Australia/Brisbane",
+		"Australia/Sydney", "Australia/Melbourne", "Australia/Hobart",
+	}},
+	{"Pacific", []string{
+		"Pacific/Honolulu", "Pacific/Auckland", "Pacific/Fiji", "Pacific/Guam",
+	}},
+}
+
+// UpdateTimeZone applies a time zone picker action to the user, reports if anything changed.
+func UpdateTimeZone(u *store.User, p menu.Payload) (changed bool) {
+	if p.Action != "set" {
+		return false
+	}
+	name, err := p.Str(0)
+	if err != nil {
+		return false
+	}
+	changed, err = SetTimeZone(u, name)
+	return err == nil && changed
+}
+
+// TimeZoneRegion is the region to list the zones of, if any.
+func TimeZoneRegion(p menu.Payload) string {
+	if p.Action != "region" {
+		return ""
+	}
+	region, _ := p.Str(0)
+	return region
+}
+
+// SetTimeZone sets the user's time zone by its IANA name, "UTC" clears it.
+func SetTimeZone(u *store.User, name string) (changed bool, err error) {
+	if strings.EqualFold(name, "UTC") {
+		name = ""
+	}
+	if _, err := store.LoadLocation(name); err != nil {
+		return false, err
+	}
+	changed = u.TimeZone != name
+	u.TimeZone = name
+	return changed, nil
+}
+
+// TimeZoneMenuLine renders a line describing the user's time zone.
+func TimeZoneMenuLine(u *store.User, now time.Time) string {
+	name := u.TimeZone
+	if name == "" {
+		name = "UTC"
+	}
+	return fmt.Sprintf(TimeZoneStr, name, FormatZone(now.In(u.Location())))
+}
+
+// TimeZoneAnswer renders the callback query answer for a time zone change.
+func TimeZoneAnswer(u *store.User, now time.Time) string {
+	name := u.TimeZone
+	if name == "" {
+		name = "UTC"
+	}
+	return fmt.Sprintf(TimeZoneSetStr, name, FormatZone(now.In(u.Location())))
+}
+
+// TimeZoneMenuText renders the time zone picker.
+func TimeZoneMenuText(u *store.User, now time.Time) string {
+	return strings.Join([]string{SettingsMenuTimeZoneStr, TimeZoneMenuLine(u, now), TimeZoneUsageStr}, "\n")
+}
+
+// TimeZoneMenuMarkup renders the regions, or the zones of region if it is known.
+func TimeZoneMenuMarkup(region string, now time.Time) *tgbotapi.InlineKeyboardMarkup {
+	var buttons []tgbotapi.InlineKeyboardButton
+	for _, r := range tzRegions {
+		if region == "" {
+			buttons = append(buttons, menu.Button(r.Name, menu.Payload{Screen: tzCallback, Action: "region", Args: []any{r.Name}}))
+			continue
+		}
+		if r.Name != region {
+			continue
+		}
+		for _, zone := range r.Zones {
+			loc, err := store.LoadLocation(zone)
+			if err != nil {
+				continue
+			}
+			city := strings.ReplaceAll(zone[strings.LastIndex(zone, "/")+1:], "_", " ")
+			buttons = append(buttons, menu.Button(fmt.Sprintf("%s %s", city, FormatZone(now.In(loc))),
+				menu.Payload{Screen: tzCallback, Action: "set", Args: []any{zone}}))
+		}
+	}
+	if region == "" {
+		buttons = append(buttons, menu.Button("UTC", menu.Payload{Screen: tzCallback, Action: "set", Args: []any{"UTC"}}))
+	}
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i := 0; i < len(buttons); i += 3 {
+		end := i + 3
+		if end > len(buttons) {
+			end = len(buttons)
+		}
+		rows = append(rows, buttons[i:end])
+	}
+	if region != "" {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(menu.Button("⬅️ Regions", menu.Payload{Screen: tzCallback})))
+	}
+	rows = append(rows, backRows...)
+	markup := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return &markup
+}
+
+// FormatZone renders the zone of t, its abbreviation such as "CEST" or the UTC offset if the zone
+// has none.
+func FormatZone(t time.Time) string {
+	abbr := t.Format("MST")
+	if abbr[0] == '+' || abbr[0] == '-' {
+		_, offset := t.Zone()
+		abbr = "UTC" + t.Format("-07:00")
+		if offset%3600 == 0 {
+			abbr = fmt.Sprintf("UTC%+d", offset/3600)
+		}
+	}
+	return abbr
+}
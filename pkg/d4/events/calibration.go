@@ -0,0 +1,139 @@
+package events
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// A report is matched to the predicted spawn of the same boss at most this far away from it.
+	calibrationWindow = 6 * time.Hour
+	// Reports this close to the prediction confirm it instead of re-anchoring the schedule.
+	calibrationTolerance = 10 * time.Minute
+)
+
+// Observation is a reported world boss spawn.
+type Observation struct {
+	Boss       string    `json:"boss"`
+	SpawnTime  time.Time `json:"spawn_time"`
+	ReportedBy int64     `json:"reported_by,omitempty"` // Chat ID
+	ReportedAt time.Time `json:"reported_at,omitempty"`
+}
+
+// RejectedReport is the error Calibrate returns for each report it had to skip.
+type RejectedReport struct {
+	Observation Observation
+}
+
+func (e *RejectedReport) Error() string {
+	o := e.Observation
+	return fmt.Sprintf("%s at %s does not match any predicted spawn", o.Boss, o.SpawnTime.UTC().Format(time.DateTime))
+}
+
+// RejectedReports returns the reports an error returned by Calibrate is about.
+func RejectedReports(err error) []*RejectedReport {
+	var rejected []*RejectedReport
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			rejected = append(rejected, RejectedReports(err)...)
+		}
+	} else if r, ok := err.(*RejectedReport); ok {
+		rejected = append(rejected, r)
+	}
+	return rejected
+}
+
+// Calibrate fits the schedule to reported spawns. Reports are replayed in chronological order
+// starting from the rule set the schedule was created with: a report confirming the current
+// prediction is recorded, any other report re-anchors the schedule at the spawn it describes.
+// Reports that cannot be matched to a predicted spawn of the same boss are skipped and returned
+// as RejectedReport errors. The reports the resulting prediction is based on are kept in its rule set.
+func (wbs *WorldBossSchedule) Calibrate(obs []Observation) error {
+	sorted := append([]Observation(nil), obs...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].SpawnTime.Before(sorted[j].SpawnTime)
+	})
+
+	var errs []error
+	var basedOn []Observation
+	cur := NewWorldBossScheduleWithRules(wbs.base)
+	for _, o := range sorted {
+		if _, ok := cur.match(o, calibrationTolerance); ok && len(basedOn) > 0 {
+			basedOn = append(basedOn, o)
+			continue
+		}
+		i, ok := cur.match(o, calibrationWindow)
+		if !ok {
+			errs = append(errs, &RejectedReport{Observation: o})
+			continue
+		}
+		cur = NewWorldBossScheduleWithRules(cur.rules.reanchored(i, o.SpawnTime))
+		basedOn = []Observation{o}
+	}
+	if len(basedOn) == 0 {
+		return errors.Join(errs...)
+	}
+	cur.rules.BasedOn = basedOn
+
+	wbs.mu.Lock()
+	defer wbs.mu.Unlock()
+	wbs.rules = cur.rules
+	wbs.spawns = cur.spawns
+	return errors.Join(errs...)
+}
+
+// BasedOn returns the reports the current prediction is based on.
+func (wbs *WorldBossSchedule) BasedOn() []Observation {
+	wbs.mu.Lock()
+	defer wbs.mu.Unlock()
+	return wbs.rules.BasedOn
+}
+
+// match returns index of the spawn of the reported boss closest to the report, if it is
+// at most maxDiff away.
+func (wbs *WorldBossSchedule) match(o Observation, maxDiff time.Duration) (idx int, ok bool) {
+	idx = -1
+	var bestDiff time.Duration
+	it := wbs.After(o.SpawnTime.Add(-maxDiff))
+	for {
+		i := it.idx
+		boss, _ := it.Next()
+		if boss.SpawnTime.After(o.SpawnTime.Add(maxDiff)) {
+			break
+		}
+		if !strings.EqualFold(boss.Name, o.Boss) {
+			continue
+		}
+		diff := boss.SpawnTime.Sub(o.SpawnTime)
+		if diff < 0 {
+			diff = -diff
+		}
+		if idx == -1 || diff < bestDiff {
+			idx, bestDiff = i, diff
+		}
+	}
+	return idx, idx != -1
+}
+
+// reanchored returns a copy of the rule set where the i-th spawn becomes the first one
+// and happens at t.
+func (rs *RuleSet) reanchored(i int, t time.Time) *RuleSet {
+	c := *rs
+	c.Anchor = t.UTC()
+	c.Rotation = rotated(rs.Rotation, i)
+	c.Intervals = rotated(rs.Intervals, i)
+	c.Zones = rotated(rs.Zones, i)
+	c.BasedOn = nil
+	return &c
+}
+
+func rotated[T any](s []T, n int) []T {
+	if len(s) == 0 {
+		return s
+	}
+	n %= len(s)
+	return append(append([]T(nil), s[n:]...), s[:n]...)
+}
@@ -0,0 +1,25 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCalibrateRejectsUnmatchedReports(t *testing.T) {
+	wbs := NewWorldBossSchedule()
+	at := time.Date(2023, 7, 20, 12, 0, 0, 0, time.UTC)
+	next := wbs.NextAt(at)
+	good := Observation{Boss: next.Name, SpawnTime: next.SpawnTime.Add(-30 * time.Minute)}
+	bad := Observation{Boss: "Nobody", SpawnTime: at}
+	err := wbs.Calibrate([]Observation{good, bad})
+	rejected := RejectedReports(err)
+	if len(rejected) != 1 || rejected[0].Observation != bad {
+		t.Fatalf("RejectedReports(%v) = %v, want only the report of an unknown boss", err, rejected)
+	}
+	if got := wbs.NextAt(at).SpawnTime; !got.Equal(good.SpawnTime) {
+		t.Errorf("NextAt(%s) = %s, want the accepted report at %s", at, got, good.SpawnTime)
+	}
+	if RejectedReports(wbs.Calibrate([]Observation{good})) != nil {
+		t.Error("RejectedReports() of a clean calibration is not empty")
+	}
+}
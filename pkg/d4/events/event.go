@@ -0,0 +1,134 @@
+package events
+
+import "time"
+
+type Kind string
+
+const (
+	KindWorldBoss Kind = "world-boss"
+	KindHelltide  Kind = "helltide"
+	KindLegion    Kind = "legion"
+)
+
+// Event is anything happening in Sanctuary on schedule.
+type Event interface {
+	Kind() Kind
+	Title() string    // Boss name or event name
+	Start() time.Time // UTC
+	End() time.Time   // UTC, equals Start for events without a known duration
+	Zone() string     // Empty if unknown
+}
+
+// Schedule is a source of events of a single kind.
+type Schedule interface {
+	Kind() Kind
+	NextEventAt(t time.Time) Event
+	PreviousEventAt(t time.Time) (Event, bool)
+	ActiveEventAt(t time.Time) (Event, bool)
+	UpcomingEvents(t time.Time, n int) []Event
+}
+
+var (
+	_ Schedule = (*WorldBossSchedule)(nil)
+	_ Schedule = (*HelltideSchedule)(nil)
+	_ Schedule = (*LegionSchedule)(nil)
+)
+
+// activeEvent reports whether the last event started at or before t is still going on.
+func activeEvent(prev Event, ok bool, t time.Time) (Event, bool) {
+	if !ok || !t.Before(prev.End()) {
+		return nil, false
+	}
+	return prev, true
+}
+
+func (wb WorldBoss) Kind() Kind       { return KindWorldBoss }
+func (wb WorldBoss) Title() string    { return wb.Name }
+func (wb WorldBoss) Start() time.Time { return wb.SpawnTime }
+func (wb WorldBoss) End() time.Time   { return wb.SpawnTime }
+func (wb WorldBoss) Zone() string     { return wb.Location }
+
+func (wbs *WorldBossSchedule) Kind() Kind { return KindWorldBoss }
+
+func (wbs *WorldBossSchedule) NextEventAt(t time.Time) Event {
+	return wbs.NextAt(t)
+}
+
+func (wbs *WorldBossSchedule) PreviousEventAt(t time.Time) (Event, bool) {
+	boss, ok := wbs.PreviousAt(t)
+	return boss, ok
+}
+
+func (wbs *WorldBossSchedule) ActiveEventAt(t time.Time) (Event, bool) {
+	prev, ok := wbs.PreviousEventAt(t)
+	return activeEvent(prev, ok, t)
+}
+
+func (wbs *WorldBossSchedule) UpcomingEvents(t time.Time, n int) []Event {
+	var evs []Event
+	for _, boss := range wbs.UpcomingN(t, n) {
+		evs = append(evs, boss)
+	}
+	return evs
+}
+
+func (ht Helltide) Kind() Kind       { return KindHelltide }
+func (ht Helltide) Title() string    { return "Helltide" }
+func (ht Helltide) Start() time.Time { return ht.StartTime }
+func (ht Helltide) End() time.Time   { return ht.EndTime }
+func (ht Helltide) Zone() string     { return "" }
+
+func (hts *HelltideSchedule) Kind() Kind { return KindHelltide }
+
+func (hts *HelltideSchedule) NextEventAt(t time.Time) Event {
+	return hts.NextAt(t)
+}
+
+func (hts *HelltideSchedule) PreviousEventAt(t time.Time) (Event, bool) {
+	return hts.PreviousAt(t), true
+}
+
+func (hts *HelltideSchedule) ActiveEventAt(t time.Time) (Event, bool) {
+	ht, ok := hts.ActiveAt(t)
+	if !ok {
+		return nil, false
+	}
+	return ht, true
+}
+
+func (hts *HelltideSchedule) UpcomingEvents(t time.Time, n int) []Event {
+	var evs []Event
+	for _, ht := range hts.UpcomingN(t, n) {
+		evs = append(evs, ht)
+	}
+	return evs
+}
+
+func (lg Legion) Kind() Kind       { return KindLegion }
+func (lg Legion) Title() string    { return "Legion" }
+func (lg Legion) Start() time.Time { return lg.SpawnTime }
+func (lg Legion) End() time.Time   { return lg.SpawnTime }
+func (lg Legion) Zone() string     { return "" }
+
+func (ls *LegionSchedule) Kind() Kind { return KindLegion }
+
+func (ls *LegionSchedule) NextEventAt(t time.Time) Event {
+	return ls.NextAt(t)
+}
+
+func (ls *LegionSchedule) PreviousEventAt(t time.Time) (Event, bool) {
+	return ls.PreviousAt(t), true
+}
+
+func (ls *LegionSchedule) ActiveEventAt(t time.Time) (Event, bool) {
+	prev, ok := ls.PreviousEventAt(t)
+	return activeEvent(prev, ok, t)
+}
+
+func (ls *LegionSchedule) UpcomingEvents(t time.Time, n int) []Event {
+	var evs []Event
+	for _, lg := range ls.UpcomingN(t, n) {
+		evs = append(evs, lg)
+	}
+	return evs
+}
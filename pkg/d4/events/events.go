@@ -1,100 +1,206 @@
 package events
 
-import "time"
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tetra5/diabler/pkg/clock"
+)
 
 func NewWorldBossSchedule() *WorldBossSchedule {
-	wb := &WorldBossSchedule{Length: 1000}
+	return NewWorldBossScheduleWithRules(DefaultRuleSet())
+}
+
+func NewWorldBossScheduleWithRules(rules *RuleSet) *WorldBossSchedule {
+	wb := &WorldBossSchedule{rules: rules, Clock: clock.Real{}, base: rules}
 	wb.Init()
 	return wb
 }
 
+// WorldBossSchedule computes spawns on demand, there is no upper bound on how far
+// into the future it can look. It is safe for concurrent use as long as Clock is not changed
+// after the schedule is shared, use Calibrate to change the rules.
 type WorldBossSchedule struct {
-	Entries       map[int]WorldBoss
-	bossNames     map[int]string
-	spawnPattern  []int
-	minutePattern []float64
-	lastSpawnIdx  int
-	Length        int
+	Clock  clock.Clock // Only Next and Snapshot use it, the other queries take an explicit time
+	base   *RuleSet    // Rules before calibration
+	mu     sync.Mutex  // Guards rules and spawns
+	rules  *RuleSet
+	spawns *spawnList
+}
+
+// RuleSet returns the rules the schedule follows, calibrated ones after Calibrate. They are
+// shared and must not be changed.
+func (wbs *WorldBossSchedule) RuleSet() *RuleSet {
+	wbs.mu.Lock()
+	defer wbs.mu.Unlock()
+	return wbs.rules
+}
+
+// spawnList holds the spawns of a single rule set. Calibrate swaps in a new list instead of
+// changing one, so every query and iterator works on the list it started with.
+type spawnList struct {
+	rules   *RuleSet
+	mu      sync.Mutex
+	entries []WorldBoss // Spawns computed so far, in order, never modified once appended
 }
 
 type WorldBoss struct {
 	Name      string
+	Location  string    // Zone and territory, empty if the rule set has no zones
 	SpawnTime time.Time // UTC
 }
 
+// Snapshot is a consistent view of the schedule at a single point in time.
+type Snapshot struct {
+	At          time.Time
+	Next        WorldBoss
+	Previous    WorldBoss // Zero value if At precedes the anchor
+	HasPrevious bool
+}
+
+// WorldBossIterator walks the schedule in spawn order. It keeps walking the schedule it was
+// created from even if the schedule is calibrated meanwhile.
+type WorldBossIterator struct {
+	spawns *spawnList
+	idx    int
+	until  time.Time // Zero value means no limit
+}
+
 func (wbs *WorldBossSchedule) Init() {
-	// Patterns are broken as of Season 2.
-	wbs.bossNames = map[int]string{
-		1: "Wandering Death",
-		2: "Avarice",
-		3: "Ashava",
+	wbs.mu.Lock()
+	defer wbs.mu.Unlock()
+	wbs.spawns = newSpawnList(wbs.rules)
+}
+
+func newSpawnList(rules *RuleSet) *spawnList {
+	return &spawnList{rules: rules, entries: []WorldBoss{{ // First WB spawn of the season
+		Name:      rules.Bosses[rules.Rotation[0]],
+		Location:  rules.zone(0),
+		SpawnTime: rules.Anchor.UTC(),
+	}}}
+}
+
+// list returns the current spawn list.
+func (wbs *WorldBossSchedule) list() *spawnList {
+	wbs.mu.Lock()
+	defer wbs.mu.Unlock()
+	return wbs.spawns
+}
+
+// extend computes one more spawn and appends it to the entries. Caller must hold l.mu.
+func (l *spawnList) extend() {
+	rules := l.rules
+	i := len(l.entries)
+	p := i % len(rules.Rotation)
+	m := i % len(rules.Intervals)
+	t := l.entries[i-1].SpawnTime.Add(minutes(rules.Intervals[m]))
+	// Spawn time must belong to one of the spawn windows otherwise we shift it
+	if len(rules.Windows) > 0 && !rules.Windows.Contains(t) {
+		t = t.Add(minutes(rules.WindowShift))
 	}
-	wbs.spawnPattern = []int{
-		1, 1, 1, 2, 2, 3, 3, 3, 1, 1, 2, 2, 2, 3, 3,
-		1, 1, 1, 2, 2, 3, 3, 3, 1, 1, 2, 2, 2, 3, 3,
-		1, 1, 1, 2, 2, 3, 3,
+	l.entries = append(l.entries, WorldBoss{
+		Name:      rules.Bosses[rules.Rotation[p]],
+		Location:  rules.zone(i),
+		SpawnTime: t,
+	})
+}
+
+// entry returns i-th spawn of the season computing it first if needed. Caller must hold l.mu.
+func (l *spawnList) entry(i int) WorldBoss {
+	for len(l.entries) <= i {
+		l.extend()
 	}
+	return l.entries[i]
+}
 
-	wbs.minutePattern = []float64{353, 353.49, 325.71, 353.49, 325.22} // Repeats
+// indexAfter returns index of the first spawn strictly after t. Caller must hold l.mu.
+func (l *spawnList) indexAfter(t time.Time) int {
+	for !l.entries[len(l.entries)-1].SpawnTime.After(t) {
+		l.extend()
+	}
+	return sort.Search(len(l.entries), func(i int) bool {
+		return l.entries[i].SpawnTime.After(t)
+	})
+}
+
+// around returns index of the first spawn strictly after t along with that spawn and the one
+// before it, if any.
+func (l *spawnList) around(t time.Time) (i int, next WorldBoss, prev WorldBoss, hasPrev bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	i = l.indexAfter(t)
+	next = l.entries[i]
+	if i > 0 {
+		prev, hasPrev = l.entries[i-1], true
+	}
+	return i, next, prev, hasPrev
+}
 
-	wbs.lastSpawnIdx = 0
-	wbs.Entries = make(map[int]WorldBoss, wbs.Length)
+// After returns an unbounded iterator over spawns strictly after t.
+func (wbs *WorldBossSchedule) After(t time.Time) *WorldBossIterator {
+	l := wbs.list()
+	i, _, _, _ := l.around(t)
+	return &WorldBossIterator{spawns: l, idx: i}
+}
+
+// Between returns an iterator over spawns strictly after from and before to.
+func (wbs *WorldBossSchedule) Between(from time.Time, to time.Time) *WorldBossIterator {
+	it := wbs.After(from)
+	it.until = to
+	return it
+}
 
-	wbs.Entries[0] = WorldBoss{ // First ever WB spawn
-		Name:      wbs.bossNames[1],
-		SpawnTime: time.Date(2023, 6, 11, 6, 0, 0, 0, time.UTC),
+// Next returns the next spawn, ok is false once the iterator is exhausted.
+func (it *WorldBossIterator) Next() (boss WorldBoss, ok bool) {
+	it.spawns.mu.Lock()
+	boss = it.spawns.entry(it.idx)
+	it.spawns.mu.Unlock()
+	if !it.until.IsZero() && !boss.SpawnTime.Before(it.until) {
+		return WorldBoss{}, false
 	}
+	it.idx++
+	return boss, true
+}
 
-	pLen := len(wbs.spawnPattern)
-	mLen := len(wbs.minutePattern)
-	for i, p, m := 1, 1, 1; i < wbs.Length; i++ {
-		if m >= mLen {
-			m = 0
-		}
-		if p >= pLen {
-			p = 0
-		}
-		t := wbs.Entries[i-1].SpawnTime.Add(time.Duration(wbs.minutePattern[m] * float64(time.Minute)))
-		// Spawn time must belong to specific time intervals otherwise we add 2 hours
-		// 04:30 - 06:30
-		t1 := time.Date(t.Year(), t.Month(), t.Day(), 4, 30, 0, 0, t.Location())
-		t2 := time.Date(t.Year(), t.Month(), t.Day(), 6, 30, 0, 0, t.Location())
-		// 10:30 - 12:30
-		t3 := time.Date(t.Year(), t.Month(), t.Day(), 10, 30, 0, 0, t.Location())
-		t4 := time.Date(t.Year(), t.Month(), t.Day(), 12, 30, 0, 0, t.Location())
-		// 16:30 - 18:30
-		t5 := time.Date(t.Year(), t.Month(), t.Day(), 16, 30, 0, 0, t.Location())
-		t6 := time.Date(t.Year(), t.Month(), t.Day(), 18, 30, 0, 0, t.Location())
-		// 22:30 - 00:30
-		t7 := time.Date(t.Year(), t.Month(), t.Day(), 22, 30, 0, 0, t.Location())
-		t8 := time.Date(t.Year(), t.Month(), t.Day(), 0, 30, 0, 0, t.Location())
-
-		if (t.After(t1) && t.Before(t2)) ||
-			(t.After(t3) && t.Before(t4)) ||
-			(t.After(t5) && t.Before(t6)) ||
-			(t.After(t7) && t.After(t8)) {
-		} else {
-			t = t.Add(time.Duration(2 * time.Hour))
-		}
-
-		wbs.Entries[i] = WorldBoss{
-			Name:      wbs.bossNames[wbs.spawnPattern[p]],
-			SpawnTime: t,
-		}
-		p++
-		m++
+// NextAt returns the first spawn strictly after t.
+func (wbs *WorldBossSchedule) NextAt(t time.Time) WorldBoss {
+	_, next, _, _ := wbs.list().around(t)
+	return next
+}
+
+// PreviousAt returns the last spawn at or before t, ok is false if t precedes the anchor.
+func (wbs *WorldBossSchedule) PreviousAt(t time.Time) (boss WorldBoss, ok bool) {
+	_, _, prev, ok := wbs.list().around(t)
+	return prev, ok
+}
+
+// UpcomingN returns n spawns following t, none if n is not positive.
+func (wbs *WorldBossSchedule) UpcomingN(t time.Time, n int) []WorldBoss {
+	if n <= 0 {
+		return nil
 	}
+	bosses := make([]WorldBoss, 0, n)
+	it := wbs.After(t)
+	for len(bosses) < n {
+		boss, _ := it.Next()
+		bosses = append(bosses, boss)
+	}
+	return bosses
 }
 
 func (wbs *WorldBossSchedule) Next() WorldBoss {
-	now := time.Now().UTC()
-	var i int
-	for i = wbs.lastSpawnIdx; i < len(wbs.Entries); i++ {
-		boss := wbs.Entries[i]
-		if now.Before(boss.SpawnTime) {
-			wbs.lastSpawnIdx = i - 1
-			break
-		}
-	}
-	return wbs.Entries[i]
+	return wbs.NextAt(wbs.Clock.Now().UTC())
+}
+
+// SnapshotAt returns the spawns surrounding t in one go so callers never mix up two bosses
+// when the next spawn happens between their queries.
+func (wbs *WorldBossSchedule) SnapshotAt(t time.Time) Snapshot {
+	snap := Snapshot{At: t}
+	_, snap.Next, snap.Previous, snap.HasPrevious = wbs.list().around(t)
+	return snap
+}
+
+func (wbs *WorldBossSchedule) Snapshot() Snapshot {
+	return wbs.SnapshotAt(wbs.Clock.Now().UTC())
 }
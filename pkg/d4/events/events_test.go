@@ -0,0 +1,112 @@
+package events
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConcurrentReaders runs queries which extend the shared spawn list from many goroutines,
+// run it with -race. Every answer must match the one computed up front from a single goroutine.
+func TestConcurrentReaders(t *testing.T) {
+	const readers = 8
+	start := time.Date(2023, 6, 11, 0, 0, 0, 0, time.UTC)
+	times := make([]time.Time, 200)
+	for i := range times {
+		times[i] = start.Add(time.Duration(i) * 7 * time.Hour)
+	}
+	ref := NewWorldBossSchedule()
+	wantNext := make([]WorldBoss, len(times))
+	wantSnap := make([]Snapshot, len(times))
+	wantDay := make([][]WorldBoss, len(times))
+	for i, at := range times {
+		wantNext[i] = ref.NextAt(at)
+		wantSnap[i] = ref.SnapshotAt(at)
+		it := ref.Between(at, at.Add(24*time.Hour))
+		for boss, ok := it.Next(); ok; boss, ok = it.Next() {
+			wantDay[i] = append(wantDay[i], boss)
+		}
+	}
+
+	wbs := NewWorldBossSchedule()
+	var wg sync.WaitGroup
+	errs := make(chan string, readers*len(times))
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			// Every reader walks the times from a different offset so they race for new spawns
+			for j := range times {
+				i := (j + r*len(times)/readers) % len(times)
+				at := times[i]
+				switch r % 3 {
+				case 0:
+					if got := wbs.NextAt(at); got != wantNext[i] {
+						errs <- fmt.Sprintf("NextAt(%s) = %v, want %v", at, got, wantNext[i])
+					}
+				case 1:
+					if got := wbs.SnapshotAt(at); got != wantSnap[i] {
+						errs <- fmt.Sprintf("SnapshotAt(%s) = %v, want %v", at, got, wantSnap[i])
+					}
+				case 2:
+					var got []WorldBoss
+					it := wbs.Between(at, at.Add(24*time.Hour))
+					for boss, ok := it.Next(); ok; boss, ok = it.Next() {
+						got = append(got, boss)
+					}
+					if fmt.Sprint(got) != fmt.Sprint(wantDay[i]) {
+						errs <- fmt.Sprintf("Between(%s) = %v, want %v", at, got, wantDay[i])
+					}
+				}
+			}
+		}(r)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// TestReadersDuringCalibration checks queries racing with Calibrate never mix the spawn lists of
+// two calibrations, which would return spawns far from the time asked about.
+func TestReadersDuringCalibration(t *testing.T) {
+	wbs := NewWorldBossSchedule()
+	at := time.Date(2023, 7, 20, 12, 0, 0, 0, time.UTC)
+	// Re-anchoring at spawns far apart makes the index of a spawn differ a lot between the lists
+	early, late := wbs.NextAt(at.AddDate(0, 0, -30)), wbs.NextAt(at)
+	reports := [][]Observation{
+		{{Boss: early.Name, SpawnTime: early.SpawnTime.Add(time.Hour)}},
+		{{Boss: late.Name, SpawnTime: late.SpawnTime.Add(-time.Hour)}},
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := wbs.Calibrate(reports[i%len(reports)]); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 20000; i++ {
+		at := at.Add(time.Duration(i%2000) * time.Minute)
+		next := wbs.NextAt(at)
+		if !next.SpawnTime.After(at) || next.SpawnTime.Sub(at) > 12*time.Hour {
+			t.Fatalf("NextAt(%s) = %s", at, next.SpawnTime)
+		}
+		snap := wbs.SnapshotAt(at)
+		if snap.HasPrevious && snap.Previous.SpawnTime.After(at) || !snap.Next.SpawnTime.After(at) {
+			t.Fatalf("SnapshotAt(%s) = %v", at, snap)
+		}
+	}
+	close(stop)
+	<-done
+}
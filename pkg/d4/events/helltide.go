@@ -0,0 +1,136 @@
+package events
+
+import (
+	"time"
+
+	"github.com/tetra5/diabler/pkg/clock"
+)
+
+func NewHelltideSchedule() *HelltideSchedule {
+	return NewHelltideScheduleWithRules(DefaultRuleSet())
+}
+
+// NewHelltideScheduleWithRules follows the Helltide cycle of rules, the built-in one if rules
+// have none.
+func NewHelltideScheduleWithRules(rules *RuleSet) *HelltideSchedule {
+	c := rules.Helltide
+	if c == nil {
+		c = DefaultRuleSet().Helltide
+	}
+	return &HelltideSchedule{
+		Anchor:   c.Anchor,
+		Period:   minutes(c.Period),
+		Duration: minutes(c.Duration),
+		Clock:    clock.Real{},
+	}
+}
+
+// HelltideSchedule is a fixed cycle: a Helltide starts every Period counting from Anchor
+// and lasts for Duration. It holds no state and is safe for concurrent use.
+type HelltideSchedule struct {
+	Anchor   time.Time
+	Period   time.Duration
+	Duration time.Duration
+	Clock    clock.Clock // Only Next and Active use it, the other queries take an explicit time
+}
+
+type Helltide struct {
+	StartTime time.Time // UTC
+	EndTime   time.Time // UTC
+}
+
+// HelltideIterator walks the schedule in start time order.
+type HelltideIterator struct {
+	hts   *HelltideSchedule
+	idx   int64
+	until time.Time // Zero value means no limit
+}
+
+func (ht Helltide) ActiveAt(t time.Time) bool {
+	return !t.Before(ht.StartTime) && t.Before(ht.EndTime)
+}
+
+func (hts *HelltideSchedule) entry(i int64) Helltide {
+	start := hts.Anchor.UTC().Add(time.Duration(i) * hts.Period)
+	return Helltide{StartTime: start, EndTime: start.Add(hts.Duration)}
+}
+
+// indexAfter returns index of the first Helltide starting strictly after t.
+func (hts *HelltideSchedule) indexAfter(t time.Time) int64 {
+	return cycleIndexAfter(hts.Anchor, hts.Period, t)
+}
+
+// cycleIndexAfter returns index of the first occurrence of an event repeating every period
+// since anchor that happens strictly after t.
+func cycleIndexAfter(anchor time.Time, period time.Duration, t time.Time) int64 {
+	elapsed := t.Sub(anchor)
+	i := int64(elapsed / period)
+	if elapsed%period < 0 {
+		// Round towards the past, the cycle extends before the anchor as well
+		i--
+	}
+	return i + 1
+}
+
+// After returns an unbounded iterator over Helltides starting strictly after t.
+func (hts *HelltideSchedule) After(t time.Time) *HelltideIterator {
+	return &HelltideIterator{hts: hts, idx: hts.indexAfter(t)}
+}
+
+// Between returns an iterator over Helltides starting strictly after from and before to.
+func (hts *HelltideSchedule) Between(from time.Time, to time.Time) *HelltideIterator {
+	it := hts.After(from)
+	it.until = to
+	return it
+}
+
+// Next returns the next Helltide, ok is false once the iterator is exhausted.
+func (it *HelltideIterator) Next() (ht Helltide, ok bool) {
+	ht = it.hts.entry(it.idx)
+	if !it.until.IsZero() && !ht.StartTime.Before(it.until) {
+		return Helltide{}, false
+	}
+	it.idx++
+	return ht, true
+}
+
+// NextAt returns the first Helltide starting strictly after t.
+func (hts *HelltideSchedule) NextAt(t time.Time) Helltide {
+	return hts.entry(hts.indexAfter(t))
+}
+
+// PreviousAt returns the last Helltide started at or before t. It may still be active.
+func (hts *HelltideSchedule) PreviousAt(t time.Time) Helltide {
+	return hts.entry(hts.indexAfter(t) - 1)
+}
+
+// UpcomingN returns n Helltides following t, none if n is not positive.
+func (hts *HelltideSchedule) UpcomingN(t time.Time, n int) []Helltide {
+	if n <= 0 {
+		return nil
+	}
+	helltides := make([]Helltide, 0, n)
+	it := hts.After(t)
+	for len(helltides) < n {
+		ht, _ := it.Next()
+		helltides = append(helltides, ht)
+	}
+	return helltides
+}
+
+// ActiveAt returns the Helltide going on at t if there is one.
+func (hts *HelltideSchedule) ActiveAt(t time.Time) (ht Helltide, ok bool) {
+	ht = hts.PreviousAt(t)
+	if ht.ActiveAt(t) {
+		return ht, true
+	}
+	return Helltide{}, false
+}
+
+func (hts *HelltideSchedule) Next() Helltide {
+	return hts.NextAt(hts.Clock.Now().UTC())
+}
+
+func (hts *HelltideSchedule) Active() (Helltide, bool) {
+	return hts.ActiveAt(hts.Clock.Now().UTC())
+}
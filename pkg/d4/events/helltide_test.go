@@ -0,0 +1,69 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tetra5/diabler/pkg/clock"
+)
+
+func TestHelltideFollowsClock(t *testing.T) {
+	start := time.Date(2023, 7, 1, 12, 30, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	hts := NewHelltideSchedule()
+	hts.Clock = clk
+
+	if ht, ok := hts.Active(); !ok || !ht.StartTime.Equal(time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Active() at 12:30 = %v, %v, want the 12:00 Helltide", ht, ok)
+	}
+	if got, want := hts.Next().StartTime, time.Date(2023, 7, 1, 13, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Fatalf("Next() at 12:30 = %v, want %v", got, want)
+	}
+
+	clk.Advance(26 * time.Minute) // 12:56, the 12:00 Helltide ended at 12:55
+	if ht, ok := hts.Active(); ok {
+		t.Fatalf("Active() at 12:56 = %v, want none", ht)
+	}
+	clk.Advance(4 * time.Minute) // 13:00 sharp, the Helltide starting now is active but not next
+	if ht, ok := hts.Active(); !ok || !ht.StartTime.Equal(clk.Now()) {
+		t.Fatalf("Active() at 13:00 = %v, %v, want the 13:00 Helltide", ht, ok)
+	}
+	if got, want := hts.Next().StartTime, time.Date(2023, 7, 1, 14, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Fatalf("Next() at 13:00 = %v, want %v", got, want)
+	}
+}
+
+func TestUpcomingNNotPositive(t *testing.T) {
+	now := time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)
+	schedules := []Schedule{NewWorldBossSchedule(), NewHelltideSchedule(), NewLegionSchedule()}
+	for _, s := range schedules {
+		for _, n := range []int{-1, 0} {
+			if evs := s.UpcomingEvents(now, n); len(evs) != 0 {
+				t.Errorf("%s UpcomingEvents(n=%d) = %d events, want none", s.Kind(), n, len(evs))
+			}
+		}
+		if evs := s.UpcomingEvents(now, 3); len(evs) != 3 {
+			t.Errorf("%s UpcomingEvents(n=3) = %d events, want 3", s.Kind(), len(evs))
+		}
+	}
+}
+
+func TestCyclesFromRules(t *testing.T) {
+	rules := DefaultRuleSet()
+	rules.Legion = &Cycle{Anchor: time.Date(2023, 7, 1, 0, 5, 0, 0, time.UTC), Period: 30}
+	rules.Helltide = nil
+	at := time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)
+	if got, want := NewLegionScheduleWithRules(rules).NextAt(at).SpawnTime, at.Add(5*time.Minute); !got.Equal(want) {
+		t.Errorf("Legion NextAt(%s) = %s, want %s", at, got, want)
+	}
+	// Without a Helltide cycle of their own, rules keep the built-in one
+	if got, want := NewHelltideScheduleWithRules(rules).NextAt(at), NewHelltideSchedule().NextAt(at); got != want {
+		t.Errorf("Helltide NextAt(%s) = %v, want %v", at, got, want)
+	}
+
+	rules.Helltide = &Cycle{Anchor: at, Period: 60, Duration: 90}
+	rules.Legion = &Cycle{Period: 25}
+	if err := rules.Validate(); err == nil {
+		t.Error("Validate() accepted a Helltide longer than its period and a Legion without an anchor")
+	}
+}
@@ -0,0 +1,98 @@
+package events
+
+import (
+	"time"
+
+	"github.com/tetra5/diabler/pkg/clock"
+)
+
+func NewLegionSchedule() *LegionSchedule {
+	return NewLegionScheduleWithRules(DefaultRuleSet())
+}
+
+// NewLegionScheduleWithRules follows the Legion cycle of rules, the built-in one if rules have
+// none.
+func NewLegionScheduleWithRules(rules *RuleSet) *LegionSchedule {
+	c := rules.Legion
+	if c == nil {
+		c = DefaultRuleSet().Legion
+	}
+	return &LegionSchedule{
+		Anchor: c.Anchor,
+		Period: minutes(c.Period),
+		Clock:  clock.Real{},
+	}
+}
+
+// LegionSchedule is a fixed cycle: the Gathering Legions event starts every Period counting
+// from Anchor. It holds no state and is safe for concurrent use.
+type LegionSchedule struct {
+	Anchor time.Time
+	Period time.Duration
+	Clock  clock.Clock // Only Next uses it, the other queries take an explicit time
+}
+
+type Legion struct {
+	SpawnTime time.Time // UTC
+}
+
+// LegionIterator walks the schedule in spawn order.
+type LegionIterator struct {
+	ls    *LegionSchedule
+	idx   int64
+	until time.Time // Zero value means no limit
+}
+
+func (ls *LegionSchedule) entry(i int64) Legion {
+	return Legion{SpawnTime: ls.Anchor.UTC().Add(time.Duration(i) * ls.Period)}
+}
+
+// After returns an unbounded iterator over Legions spawning strictly after t.
+func (ls *LegionSchedule) After(t time.Time) *LegionIterator {
+	return &LegionIterator{ls: ls, idx: cycleIndexAfter(ls.Anchor, ls.Period, t)}
+}
+
+// Between returns an iterator over Legions spawning strictly after from and before to.
+func (ls *LegionSchedule) Between(from time.Time, to time.Time) *LegionIterator {
+	it := ls.After(from)
+	it.until = to
+	return it
+}
+
+// Next returns the next Legion, ok is false once the iterator is exhausted.
+func (it *LegionIterator) Next() (legion Legion, ok bool) {
+	legion = it.ls.entry(it.idx)
+	if !it.until.IsZero() && !legion.SpawnTime.Before(it.until) {
+		return Legion{}, false
+	}
+	it.idx++
+	return legion, true
+}
+
+// NextAt returns the first Legion spawning strictly after t.
+func (ls *LegionSchedule) NextAt(t time.Time) Legion {
+	return ls.entry(cycleIndexAfter(ls.Anchor, ls.Period, t))
+}
+
+// PreviousAt returns the last Legion spawned at or before t.
+func (ls *LegionSchedule) PreviousAt(t time.Time) Legion {
+	return ls.entry(cycleIndexAfter(ls.Anchor, ls.Period, t) - 1)
+}
+
+// UpcomingN returns n Legions following t, none if n is not positive.
+func (ls *LegionSchedule) UpcomingN(t time.Time, n int) []Legion {
+	if n <= 0 {
+		return nil
+	}
+	legions := make([]Legion, 0, n)
+	it := ls.After(t)
+	for len(legions) < n {
+		legion, _ := it.Next()
+		legions = append(legions, legion)
+	}
+	return legions
+}
+
+func (ls *LegionSchedule) Next() Legion {
+	return ls.NextAt(ls.Clock.Now().UTC())
+}
@@ -0,0 +1,165 @@
+package events
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// RuleSetVersion is the only rule file format version this package understands.
+const RuleSetVersion = 1
+
+//go:embed rules/*.json
+var builtinRules embed.FS
+
+// RuleSet describes how world bosses spawn during a season, along with the Helltide and Legion
+// cycles. Rule sets are kept in versioned JSON files so a new season can be shipped without
+// recompiling the bot.
+type RuleSet struct {
+	Version     int            `json:"version"`
+	Season      string         `json:"season"`
+	Anchor      time.Time      `json:"anchor"`             // First WB spawn of the season, UTC
+	Bosses      map[int]string `json:"bosses"`             // Boss ID -> boss name
+	Rotation    []int          `json:"rotation"`           // Boss IDs, repeats
+	Zones       []string       `json:"zones"`              // Location of each rotation entry, optional
+	Intervals   []float64      `json:"intervals"`          // Minutes between spawns, repeats
+	Windows     DailyWindows   `json:"windows"`            // Spawns outside of these are shifted
+	WindowShift float64        `json:"window_shift"`       // Minutes
+	BasedOn     []Observation  `json:"based_on,omitempty"` // Reports the anchor was calibrated with
+	Helltide    *Cycle         `json:"helltide,omitempty"` // Built-in one if missing
+	Legion      *Cycle         `json:"legion,omitempty"`   // Built-in one if missing
+}
+
+// Cycle is an event starting every Period counting from Anchor, before it as well.
+type Cycle struct {
+	Anchor   time.Time `json:"anchor"`             // Any start of the event, UTC
+	Period   float64   `json:"period"`             // Minutes
+	Duration float64   `json:"duration,omitempty"` // Minutes, for events lasting a while
+}
+
+func (c *Cycle) validate(name string) []error {
+	var errs []error
+	if c.Anchor.IsZero() {
+		errs = append(errs, fmt.Errorf("%s anchor is missing", name))
+	}
+	if c.Period <= 0 {
+		errs = append(errs, fmt.Errorf("%s period must be positive, got %v", name, c.Period))
+	}
+	if c.Duration < 0 || c.Duration > c.Period {
+		errs = append(errs, fmt.Errorf("%s duration must be within its period, got %v", name, c.Duration))
+	}
+	return errs
+}
+
+func minutes(m float64) time.Duration {
+	return time.Duration(m * float64(time.Minute))
+}
+
+// DefaultRuleSet returns the rule set the bot was originally built for.
+func DefaultRuleSet() *RuleSet {
+	rs, err := BuiltinRuleSet("season1")
+	if err != nil {
+		panic(err)
+	}
+	return rs
+}
+
+// BuiltinRuleSet returns one of the rule sets embedded into the binary, e.g. "season1".
+func BuiltinRuleSet(name string) (*RuleSet, error) {
+	bytes, err := builtinRules.ReadFile("rules/" + name + ".json")
+	if err != nil {
+		return nil, err
+	}
+	return ParseRuleSet(bytes)
+}
+
+// LoadRuleSet reads and validates a rule set file.
+func LoadRuleSet(fPath string) (*RuleSet, error) {
+	bytes, err := os.ReadFile(fPath)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := ParseRuleSet(bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fPath, err)
+	}
+	return rs, nil
+}
+
+func ParseRuleSet(bytes []byte) (*RuleSet, error) {
+	rs := &RuleSet{}
+	if err := json.Unmarshal(bytes, rs); err != nil {
+		return nil, err
+	}
+	if err := rs.Validate(); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+func (rs *RuleSet) Validate() error {
+	var errs []error
+	if rs.Version != RuleSetVersion {
+		errs = append(errs, fmt.Errorf("unsupported rule set version %d", rs.Version))
+	}
+	if rs.Anchor.IsZero() {
+		errs = append(errs, errors.New("anchor is missing"))
+	}
+	if len(rs.Rotation) == 0 {
+		errs = append(errs, errors.New("rotation is empty"))
+	}
+	for _, id := range rs.Rotation {
+		if _, ok := rs.Bosses[id]; !ok {
+			errs = append(errs, fmt.Errorf("rotation refers to unknown boss %d", id))
+		}
+	}
+	if len(rs.Zones) > 0 && len(rs.Zones) != len(rs.Rotation) {
+		errs = append(errs, fmt.Errorf("zones must list a location for each of %d rotation entries, got %d",
+			len(rs.Rotation), len(rs.Zones)))
+	}
+	if len(rs.Intervals) == 0 {
+		errs = append(errs, errors.New("intervals are empty"))
+	}
+	for _, m := range rs.Intervals {
+		if m <= 0 {
+			errs = append(errs, fmt.Errorf("interval must be positive, got %v", m))
+		}
+	}
+	if rs.Helltide != nil {
+		errs = append(errs, rs.Helltide.validate("helltide")...)
+	}
+	if rs.Legion != nil {
+		errs = append(errs, rs.Legion.validate("legion")...)
+	}
+	return errors.Join(errs...)
+}
+
+// BossName returns the full name of the boss s is a case-insensitive name or unambiguous
+// name prefix of.
+func (rs *RuleSet) BossName(s string) (name string, ok bool) {
+	var found []string
+	for _, name := range rs.Bosses {
+		if strings.EqualFold(name, s) {
+			return name, true
+		}
+		if s != "" && strings.HasPrefix(strings.ToLower(name), strings.ToLower(s)) {
+			found = append(found, name)
+		}
+	}
+	if len(found) != 1 {
+		return "", false
+	}
+	return found[0], true
+}
+
+// zone returns location of the i-th spawn of the season, the one of its rotation entry.
+func (rs *RuleSet) zone(i int) string {
+	if len(rs.Zones) == 0 {
+		return ""
+	}
+	return rs.Zones[i%len(rs.Zones)]
+}
@@ -0,0 +1,42 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestZonesFollowRotation(t *testing.T) {
+	rules := DefaultRuleSet()
+	rules.Zones = make([]string, len(rules.Rotation))
+	for i, id := range rules.Rotation {
+		rules.Zones[i] = rules.Bosses[id] + " lair"
+	}
+	if err := rules.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	wbs := NewWorldBossScheduleWithRules(rules)
+	// Past the end of the rotation and across a calibration the zone stays with its boss
+	it := wbs.After(rules.Anchor.AddDate(0, 0, -1))
+	for i := 0; i < 3*len(rules.Rotation); i++ {
+		boss, _ := it.Next()
+		if boss.Location != boss.Name+" lair" {
+			t.Fatalf("spawn %d of %s is in %q", i, boss.Name, boss.Location)
+		}
+	}
+	next := wbs.NextAt(rules.Anchor.AddDate(0, 0, 10))
+	if err := wbs.Calibrate([]Observation{{Boss: next.Name, SpawnTime: next.SpawnTime.Add(-20 * time.Minute)}}); err != nil {
+		t.Fatal(err)
+	}
+	it = wbs.After(next.SpawnTime.AddDate(0, 0, -1))
+	for i := 0; i < 3*len(rules.Rotation); i++ {
+		boss, _ := it.Next()
+		if boss.Location != boss.Name+" lair" {
+			t.Fatalf("spawn %d of %s after calibration is in %q", i, boss.Name, boss.Location)
+		}
+	}
+
+	rules.Zones = rules.Zones[:3]
+	if err := rules.Validate(); err == nil {
+		t.Error("Validate() accepted fewer zones than rotation entries")
+	}
+}
@@ -0,0 +1,108 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// TimeWindow is a daily UTC time interval such as 04:30 - 06:30. A window ending at or before
+// its start crosses midnight, e.g. 22:30 - 00:30. Both boundaries are exclusive.
+type TimeWindow struct {
+	Start time.Duration // Since midnight
+	End   time.Duration // Since midnight
+}
+
+// DailyWindows is a set of time windows repeating every day.
+type DailyWindows []TimeWindow
+
+// ParseTimeWindow parses "HH:MM" boundaries.
+func ParseTimeWindow(start string, end string) (w TimeWindow, err error) {
+	if w.Start, err = parseTimeOfDay(start); err != nil {
+		return w, err
+	}
+	if w.End, err = parseTimeOfDay(end); err != nil {
+		return w, err
+	}
+	return w, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("bad time of day %q: %w", s, err)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// CrossesMidnight reports whether the window ends on the day after it starts.
+func (w TimeWindow) CrossesMidnight() bool {
+	return w.End <= w.Start
+}
+
+// Contains reports whether t falls into the window. t is converted to UTC first.
+func (w TimeWindow) Contains(t time.Time) bool {
+	return w.ContainsIn(t, time.UTC)
+}
+
+// ContainsIn reports whether t falls into the window taken in local time of loc.
+func (w TimeWindow) ContainsIn(t time.Time, loc *time.Location) bool {
+	t = t.In(loc)
+	tod := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+	if w.CrossesMidnight() {
+		return tod > w.Start || tod < w.End
+	}
+	return tod > w.Start && tod < w.End
+}
+
+// EndAfter returns the first end of the window taken in local time of loc after t.
+func (w TimeWindow) EndAfter(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	hour, minute := int(w.End/time.Hour), int(w.End%time.Hour/time.Minute)
+	end := time.Date(t.Year(), t.Month(), t.Day(), hour, minute, 0, 0, loc)
+	if !end.After(t) {
+		end = time.Date(t.Year(), t.Month(), t.Day()+1, hour, minute, 0, 0, loc)
+	}
+	return end
+}
+
+func (w TimeWindow) String() string {
+	return formatTimeOfDay(w.Start) + " - " + formatTimeOfDay(w.End)
+}
+
+func formatTimeOfDay(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+}
+
+// Contains reports whether t falls into any of the windows.
+func (dw DailyWindows) Contains(t time.Time) bool {
+	for _, w := range dw {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+type timeWindowJSON struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+func (w TimeWindow) MarshalJSON() ([]byte, error) {
+	return json.Marshal(timeWindowJSON{Start: formatTimeOfDay(w.Start), End: formatTimeOfDay(w.End)})
+}
+
+func (w *TimeWindow) UnmarshalJSON(bytes []byte) error {
+	var raw timeWindowJSON
+	if err := json.Unmarshal(bytes, &raw); err != nil {
+		return err
+	}
+	parsed, err := ParseTimeWindow(raw.Start, raw.End)
+	if err != nil {
+		return err
+	}
+	*w = parsed
+	return nil
+}
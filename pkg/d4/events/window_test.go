@@ -0,0 +1,210 @@
+package events
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+func mustWindow(t *testing.T, start string, end string) TimeWindow {
+	t.Helper()
+	w, err := ParseTimeWindow(start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func mustLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestCrossesMidnight(t *testing.T) {
+	tests := []struct {
+		start, end string
+		want       bool
+	}{
+		{"04:30", "06:30", false},
+		{"22:30", "00:30", true},
+		{"23:00", "07:00", true},
+		{"22:30", "00:00", true},
+		{"00:00", "00:30", false},
+		{"12:00", "12:00", true}, // Ends the next day, a full day minus its boundary
+	}
+	for _, tt := range tests {
+		if got := mustWindow(t, tt.start, tt.end).CrossesMidnight(); got != tt.want {
+			t.Errorf("%s - %s CrossesMidnight() = %v, want %v", tt.start, tt.end, got, tt.want)
+		}
+	}
+}
+
+func TestContainsIn(t *testing.T) {
+	berlin := mustLocation(t, "Europe/Berlin")
+	kathmandu := mustLocation(t, "Asia/Kathmandu")
+	utc := func(s string) time.Time {
+		tm, err := time.Parse(time.DateTime, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	tests := []struct {
+		window [2]string
+		loc    *time.Location
+		at     time.Time
+		want   bool
+	}{
+		// Boundaries are exclusive
+		{[2]string{"04:30", "06:30"}, time.UTC, utc("2023-07-01 04:30:00"), false},
+		{[2]string{"04:30", "06:30"}, time.UTC, utc("2023-07-01 04:30:01"), true},
+		{[2]string{"04:30", "06:30"}, time.UTC, utc("2023-07-01 06:29:59"), true},
+		{[2]string{"04:30", "06:30"}, time.UTC, utc("2023-07-01 06:30:00"), false},
+		// Crossing midnight, both sides of it
+		{[2]string{"22:30", "00:30"}, time.UTC, utc("2023-07-01 22:30:00"), false},
+		{[2]string{"22:30", "00:30"}, time.UTC, utc("2023-07-01 23:59:00"), true},
+		{[2]string{"22:30", "00:30"}, time.UTC, utc("2023-07-02 00:00:00"), true},
+		{[2]string{"22:30", "00:30"}, time.UTC, utc("2023-07-02 00:01:00"), true},
+		{[2]string{"22:30", "00:30"}, time.UTC, utc("2023-07-02 00:30:00"), false},
+		{[2]string{"22:30", "00:30"}, time.UTC, utc("2023-07-02 12:00:00"), false},
+		// Ending at midnight
+		{[2]string{"22:30", "00:00"}, time.UTC, utc("2023-07-01 23:59:59"), true},
+		{[2]string{"22:30", "00:00"}, time.UTC, utc("2023-07-02 00:00:00"), false},
+		// Local time of other zones, including one with a 45 minute offset
+		{[2]string{"23:00", "07:00"}, berlin, utc("2023-07-01 21:30:00"), true},     // 23:30 CEST
+		{[2]string{"23:00", "07:00"}, berlin, utc("2023-07-01 20:30:00"), false},    // 22:30 CEST
+		{[2]string{"23:00", "07:00"}, berlin, utc("2023-01-01 22:30:00"), true},     // 23:30 CET
+		{[2]string{"23:00", "07:00"}, berlin, utc("2023-07-01 05:30:00"), false},    // 07:30 CEST
+		{[2]string{"23:00", "07:00"}, kathmandu, utc("2023-07-01 17:15:00"), false}, // 23:00 sharp
+		{[2]string{"23:00", "07:00"}, kathmandu, utc("2023-07-01 17:16:00"), true},
+		// DST days: 02:00 - 03:00 does not exist on 2023-03-26 and happens twice on 2023-10-29
+		{[2]string{"01:30", "03:30"}, berlin, utc("2023-03-26 00:45:00"), true},  // 01:45 CET
+		{[2]string{"01:30", "03:30"}, berlin, utc("2023-03-26 01:00:00"), true},  // 03:00 CEST
+		{[2]string{"01:30", "03:30"}, berlin, utc("2023-03-26 01:30:00"), false}, // 03:30 CEST
+		{[2]string{"01:30", "03:30"}, berlin, utc("2023-10-29 00:15:00"), true},  // 02:15 CEST
+		{[2]string{"01:30", "03:30"}, berlin, utc("2023-10-29 01:15:00"), true},  // 02:15 CET
+		{[2]string{"01:30", "03:30"}, berlin, utc("2023-10-29 02:30:00"), false}, // 03:30 CET
+	}
+	for _, tt := range tests {
+		w := mustWindow(t, tt.window[0], tt.window[1])
+		if got := w.ContainsIn(tt.at, tt.loc); got != tt.want {
+			t.Errorf("%s ContainsIn(%s, %s) = %v, want %v", w, tt.at.Format(time.DateTime), tt.loc, got, tt.want)
+		}
+		if tt.loc == time.UTC {
+			if got := w.Contains(tt.at); got != tt.want {
+				t.Errorf("%s Contains(%s) = %v, want %v", w, tt.at.Format(time.DateTime), got, tt.want)
+			}
+		}
+	}
+}
+
+func TestEndAfter(t *testing.T) {
+	berlin := mustLocation(t, "Europe/Berlin")
+	tests := []struct {
+		end  string
+		loc  *time.Location
+		at   string // In loc
+		want string // In loc
+	}{
+		{"07:00", time.UTC, "2023-07-01 23:30", "2023-07-02 07:00"},
+		{"07:00", time.UTC, "2023-07-02 06:59", "2023-07-02 07:00"},
+		{"07:00", time.UTC, "2023-07-02 07:00", "2023-07-03 07:00"}, // Strictly after
+		{"00:30", time.UTC, "2023-07-01 23:59", "2023-07-02 00:30"},
+		{"00:00", time.UTC, "2023-07-01 23:59", "2023-07-02 00:00"},
+		{"00:00", time.UTC, "2023-07-02 00:00", "2023-07-03 00:00"},
+		{"07:00", berlin, "2023-07-01 23:30", "2023-07-02 07:00"},
+		// The night of the DST switch is an hour shorter or longer but still ends at 07:00
+		{"07:00", berlin, "2023-03-25 23:00", "2023-03-26 07:00"},
+		{"07:00", berlin, "2023-10-28 23:00", "2023-10-29 07:00"},
+		// 02:30 does not exist on 2023-03-26, the end moves to 03:30 CEST
+		{"02:30", berlin, "2023-03-26 00:00", "2023-03-26 03:30"},
+	}
+	for _, tt := range tests {
+		w := TimeWindow{Start: 23 * time.Hour}
+		w.End, _ = parseTimeOfDay(tt.end)
+		at, err := time.ParseInLocation("2006-01-02 15:04", tt.at, tt.loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := w.EndAfter(at, tt.loc).In(tt.loc).Format("2006-01-02 15:04"); got != tt.want {
+			t.Errorf("end %s EndAfter(%s, %s) = %s, want %s", tt.end, tt.at, tt.loc, got, tt.want)
+		}
+	}
+}
+
+func TestDailyWindowsContains(t *testing.T) {
+	windows := DefaultRuleSet().Windows
+	tests := []struct {
+		at   string // UTC
+		want bool
+	}{
+		{"04:30", false},
+		{"04:31", true},
+		{"06:29", true},
+		{"06:30", false},
+		{"08:00", false},
+		{"10:31", true},
+		{"12:30", false},
+		{"17:00", true},
+		{"22:29", false},
+		{"23:59", true},
+		{"00:00", true},
+		{"00:30", false},
+		{"02:00", false},
+	}
+	for _, tt := range tests {
+		at, err := time.Parse("2006-01-02 15:04", "2023-07-01 "+tt.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := windows.Contains(at); got != tt.want {
+			t.Errorf("Contains(%s) = %v, want %v", tt.at, got, tt.want)
+		}
+	}
+	if (DailyWindows{}).Contains(time.Now()) {
+		t.Errorf("empty windows contain a time")
+	}
+}
+
+// TestSeason1Spawns pins the generated Season 1 schedule. A change to the window logic or the
+// rules shows up as a diff of testdata/season1_spawns.golden, regenerate it with -update once
+// the change is intended.
+func TestSeason1Spawns(t *testing.T) {
+	const n = 400
+	var b strings.Builder
+	for i, boss := range NewWorldBossSchedule().UpcomingN(time.Time{}, n) {
+		fmt.Fprintf(&b, "%3d %s %s\n", i, boss.SpawnTime.Format(time.DateTime), boss.Name)
+	}
+	const golden = "testdata/season1_spawns.golden"
+	if *update {
+		if err := os.WriteFile(golden, []byte(b.String()), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, wantLines := strings.Split(b.String(), "\n"), strings.Split(string(want), "\n")
+	if len(got) != len(wantLines) {
+		t.Fatalf("%d spawns, %s has %d", len(got)-1, golden, len(wantLines)-1)
+	}
+	for i, line := range wantLines {
+		if got[i] != line {
+			t.Fatalf("spawn %d differs from %s:\n got %q\nwant %q", i, golden, got[i], line)
+		}
+	}
+	// Spawns right after midnight used to be pushed out of the 22:30 - 00:30 window
+	if !strings.Contains(string(want), "175 2023-07-25 00:01:") {
+		t.Errorf("spawn 175 is not at 00:01 on 2023-07-25")
+	}
+}
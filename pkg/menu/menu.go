@@ -0,0 +1,392 @@
+// Package menu routes Telegram callback queries and commands to named screens. A screen is an
+// inline keyboard menu kept in a single chat message which is edited on every button press.
+package menu
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tetra5/diabler/pkg/clock"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Bot is the part of tgbotapi.BotAPI the router talks to.
+type Bot interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
+}
+
+// Poster is a Bot which can queue a call without waiting for it, such as outbox.Queue. Replies
+// are posted when the bot is one so a burst of button presses is not held up by flood limits and
+// only the latest state of the menu gets sent. done gets the result of the call.
+type Poster interface {
+	Post(c tgbotapi.Chattable, done func(msg tgbotapi.Message, err error))
+}
+
+const (
+	maxRendered = 10000          // Menu messages whose contents are remembered at most
+	renderedTTL = 24 * time.Hour // Menus untouched for longer are forgotten first
+)
+
+// Button makes an inline keyboard button sending the payload. It panics if the payload cannot be
+// encoded, payloads are built by the screens themselves so that is a programming error.
+func Button(text string, p Payload) tgbotapi.InlineKeyboardButton {
+	data, err := Encode(p)
+	if err != nil {
+		panic(fmt.Sprintf("menu: button %q: %s", text, err))
+	}
+	return tgbotapi.NewInlineKeyboardButtonData(text, data)
+}
+
+// Request is a single callback query or command going through the router.
+type Request struct {
+	Update  tgbotapi.Update
+	ChatID  int64
+	Now     time.Time
+	Payload Payload // Callback queries only
+	Command string  // Commands only, without the slash
+	Args    string  // Command arguments
+	// State is whatever middleware loads for the handlers, such as the settings of the chat.
+	State any
+
+	// Reply, filled in by the screen or command
+	Text       string
+	Markup     *tgbotapi.InlineKeyboardMarkup
+	NewMessage bool   // Send the reply as a new message instead of editing the menu
+	Answer     string // Callback query answer shown as a toast, empty shows nothing
+	Alert      bool   // Show Answer as a dialog to be dismissed instead of a toast
+	Unchanged  string // Answer instead of Answer if the menu renders the same as before
+
+	changed bool
+	saved   []func()
+	sent    []func(msg tgbotapi.Message)
+}
+
+// Name identifies the request in logs: the callback data or the command.
+func (r *Request) Name() string {
+	if r.Update.CallbackQuery != nil {
+		return r.Update.CallbackQuery.Data
+	}
+	return "/" + r.Command
+}
+
+// Changed marks State as modified, it is saved once the request is handled, see SaveState.
+func (r *Request) Changed() {
+	r.changed = true
+}
+
+// OnSaved registers fn to run after the modified State is saved.
+func (r *Request) OnSaved(fn func()) {
+	r.saved = append(r.saved, fn)
+}
+
+// OnSent registers fn to run after the reply is sent as a new message. It runs on another
+// goroutine when the bot is a Poster.
+func (r *Request) OnSent(fn func(msg tgbotapi.Message)) {
+	r.sent = append(r.sent, fn)
+}
+
+// Screen is a menu screen. Its buttons send payloads with the screen's Name.
+type Screen struct {
+	Name string // Callback data prefix such as "diabler-settings-alarm"
+	// Handle applies the payload action to the request, typically changing r.State. It is
+	// optional, screens without actions are only rendered.
+	Handle func(r *Request) error
+	Render func(r *Request) (text string, markup *tgbotapi.InlineKeyboardMarkup)
+	// NewMessage sends the screen as a new message instead of editing the menu.
+	NewMessage bool
+}
+
+// Command handles a chat command by filling in the request reply.
+type Command struct {
+	Name   string // Without the slash
+	Handle func(r *Request) error
+}
+
+type Handler func(r *Request) error
+
+// Middleware wraps the handling of every request, see SaveState and ReportErrors.
+type Middleware func(next Handler) Handler
+
+type Router struct {
+	Clock      clock.Clock
+	Expired    string // Callback query answer for payloads which no longer decode
+	Unchanged  string // Callback query answer for presses which change nothing
+	bot        Bot
+	screens    map[string]Screen
+	commands   map[string]Command
+	middleware []Middleware
+
+	mu       sync.Mutex
+	rendered map[messageKey]rendering // What each menu message was last edited to show
+}
+
+type messageKey struct {
+	chatID    int64
+	messageID int
+}
+
+type rendering struct {
+	sum uint64 // Hash of the text and markup
+	at  time.Time
+}
+
+func NewRouter(bot Bot) *Router {
+	return &Router{
+		Clock:     clock.Real{},
+		Expired:   "Menu expired, please reopen it.",
+		Unchanged: "Nothing to change.",
+		rendered:  map[messageKey]rendering{},
+		bot:       bot,
+		screens:   map[string]Screen{},
+		commands:  map[string]Command{},
+	}
+}
+
+// Screen registers a screen, a screen with the same name is replaced.
+func (rt *Router) Screen(s Screen) {
+	rt.screens[s.Name] = s
+}
+
+// Command registers a command, a command with the same name is replaced.
+func (rt *Router) Command(c Command) {
+	rt.commands[c.Name] = c
+}
+
+// Use appends middleware, the first one added is the outermost.
+func (rt *Router) Use(mw ...Middleware) {
+	rt.middleware = append(rt.middleware, mw...)
+}
+
+// Dispatch handles an update, updates other than commands and callback queries are ignored.
+func (rt *Router) Dispatch(update tgbotapi.Update) {
+	r := &Request{Update: update, Now: rt.Clock.Now()}
+	switch {
+	case update.CallbackQuery != nil && update.CallbackQuery.Message != nil:
+		r.ChatID = update.CallbackQuery.Message.Chat.ID
+		p, err := Decode(update.CallbackQuery.Data)
+		if _, ok := rt.screens[p.Screen]; err == nil && !ok {
+			err = fmt.Errorf("%w: unknown screen %q", ErrExpired, p.Screen)
+		}
+		if err != nil {
+			log.Printf("Rejected callback %q from %d: %s", update.CallbackQuery.Data, r.ChatID, err)
+			r.Answer, r.Alert = rt.Expired, true
+			rt.answer(r)
+			return
+		}
+		r.Payload = p
+	case update.Message != nil && update.Message.IsCommand():
+		if _, ok := rt.commands[update.Message.Command()]; !ok {
+			return
+		}
+		r.ChatID = update.Message.Chat.ID
+		r.Command = update.Message.Command()
+		r.Args = update.Message.CommandArguments()
+	default:
+		return
+	}
+	h := rt.handle
+	for i := len(rt.middleware) - 1; i >= 0; i-- {
+		h = rt.middleware[i](h)
+	}
+	if err := h(r); err != nil {
+		log.Printf("Error handling %q from %d: %s", r.Name(), r.ChatID, err)
+	}
+	rt.reply(r)
+}
+
+func (rt *Router) handle(r *Request) error {
+	if r.Command != "" {
+		return rt.commands[r.Command].Handle(r)
+	}
+	s := rt.screens[r.Payload.Screen]
+	if s.Handle != nil {
+		if err := s.Handle(r); err != nil {
+			return err
+		}
+	}
+	r.Text, r.Markup = s.Render(r)
+	r.NewMessage = r.NewMessage || s.NewMessage
+	return nil
+}
+
+func (rt *Router) answer(r *Request) {
+	if r.Update.CallbackQuery == nil {
+		return
+	}
+	answer := tgbotapi.NewCallback(r.Update.CallbackQuery.ID, r.Answer)
+	answer.ShowAlert = r.Alert
+	_, err := rt.bot.Request(answer)
+	if err != nil {
+		log.Printf("Error answering %q: %s", r.Name(), err)
+	}
+}
+
+func (rt *Router) reply(r *Request) {
+	if r.Text == "" {
+		rt.answer(r)
+		return
+	}
+	if r.Command != "" || r.NewMessage {
+		rt.answer(r)
+		msg := tgbotapi.NewMessage(r.ChatID, r.Text)
+		msg.ParseMode = tgbotapi.ModeMarkdown
+		if r.Markup != nil {
+			msg.ReplyMarkup = *r.Markup
+		}
+		rt.post(msg, func(sent tgbotapi.Message, err error) {
+			if err != nil {
+				log.Printf("Error sending %q reply to %d: %s", r.Name(), r.ChatID, err)
+				return
+			}
+			rt.remember(messageKey{r.ChatID, sent.MessageID}, renderSum(r.Text, r.Markup), r.Now)
+			for _, fn := range r.sent {
+				fn(sent)
+			}
+		})
+		return
+	}
+	markup := tgbotapi.NewInlineKeyboardMarkup()
+	if r.Markup != nil {
+		markup = *r.Markup
+	}
+	key := messageKey{r.ChatID, r.Update.CallbackQuery.Message.MessageID}
+	sum := renderSum(r.Text, &markup)
+	// Telegram refuses edits which change nothing with "message is not modified". The edit is
+	// remembered before it is made so presses arriving meanwhile are compared against it.
+	if !rt.remember(key, sum, r.Now) {
+		r.Answer = r.Unchanged
+		if r.Answer == "" {
+			r.Answer = rt.Unchanged
+		}
+		rt.answer(r)
+		return
+	}
+	rt.answer(r)
+	edit := tgbotapi.NewEditMessageTextAndMarkup(key.chatID, key.messageID, r.Text, markup)
+	edit.ParseMode = tgbotapi.ModeMarkdown
+	rt.post(edit, func(_ tgbotapi.Message, err error) {
+		if err != nil {
+			log.Printf("Error editing %q message for %d: %s", r.Name(), r.ChatID, err)
+			rt.forget(key, sum)
+		}
+	})
+}
+
+// post sends c without waiting for it if the bot is a Poster, done gets the result either way.
+func (rt *Router) post(c tgbotapi.Chattable, done func(msg tgbotapi.Message, err error)) {
+	if p, ok := rt.bot.(Poster); ok {
+		p.Post(c, done)
+		return
+	}
+	done(rt.bot.Send(c))
+}
+
+// renderSum hashes what a menu message shows, only the hash is remembered.
+func renderSum(text string, markup *tgbotapi.InlineKeyboardMarkup) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(text))
+	h.Write([]byte{0})
+	if markup != nil && len(markup.InlineKeyboard) > 0 {
+		data, _ := json.Marshal(markup)
+		h.Write(data)
+	}
+	return h.Sum64()
+}
+
+// remember records what a menu message shows, it reports false if the message shows it already.
+// Menus shown before a restart or forgotten since are unknown and are always edited.
+func (rt *Router) remember(key messageKey, sum uint64, now time.Time) bool {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if r, ok := rt.rendered[key]; ok && r.sum == sum {
+		rt.rendered[key] = rendering{sum, now}
+		return false
+	}
+	if _, ok := rt.rendered[key]; !ok && len(rt.rendered) >= maxRendered {
+		rt.expireLocked(now)
+	}
+	rt.rendered[key] = rendering{sum, now}
+	return true
+}
+
+// forget drops what remember recorded for a failed edit, unless a later edit replaced it.
+func (rt *Router) forget(key messageKey, sum uint64) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.rendered[key].sum == sum {
+		delete(rt.rendered, key)
+	}
+}
+
+// expireLocked makes room for another menu: menus untouched for renderedTTL are forgotten and if
+// that is not enough, the older half of the rest.
+func (rt *Router) expireLocked(now time.Time) {
+	for key, r := range rt.rendered {
+		if now.Sub(r.at) > renderedTTL {
+			delete(rt.rendered, key)
+		}
+	}
+	if len(rt.rendered) < maxRendered {
+		return
+	}
+	times := make([]time.Time, 0, len(rt.rendered))
+	for _, r := range rt.rendered {
+		times = append(times, r.at)
+	}
+	sort.Slice(times, func(a, b int) bool { return times[a].Before(times[b]) })
+	cutoff := times[len(times)/2]
+	for key, r := range rt.rendered {
+		if !r.at.After(cutoff) {
+			delete(rt.rendered, key)
+		}
+	}
+}
+
+// SaveState calls save once a request has called Changed and then runs the OnSaved callbacks.
+// Requests which failed are not saved.
+func SaveState(save func(r *Request) error) Middleware {
+	return func(next Handler) Handler {
+		return func(r *Request) error {
+			if err := next(r); err != nil {
+				return err
+			}
+			if !r.changed {
+				return nil
+			}
+			if err := save(r); err != nil {
+				return err
+			}
+			for _, fn := range r.saved {
+				fn()
+			}
+			return nil
+		}
+	}
+}
+
+// ReportErrors logs a failed request and reports text instead of replying: as an alert for
+// callback queries, the menu is left as it was, and as a message for commands.
+func ReportErrors(text string) Middleware {
+	return func(next Handler) Handler {
+		return func(r *Request) error {
+			err := next(r)
+			if err == nil {
+				return nil
+			}
+			log.Printf("Error handling %q from %d: %s", r.Name(), r.ChatID, err)
+			if r.Update.CallbackQuery != nil {
+				r.Text, r.Markup, r.Answer, r.Alert = "", nil, text, true
+				return nil
+			}
+			r.Text, r.Markup, r.NewMessage = text, nil, true
+			return nil
+		}
+	}
+}
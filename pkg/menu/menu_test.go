@@ -0,0 +1,129 @@
+package menu
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// fakeBot fails the given number of edits and records the rest along with callback answers.
+type fakeBot struct {
+	failEdits int
+	edits     int
+	posts     int
+	answers   []string
+}
+
+func (b *fakeBot) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	if _, ok := c.(tgbotapi.EditMessageTextConfig); ok {
+		if b.failEdits > 0 {
+			b.failEdits--
+			return tgbotapi.Message{}, errors.New("Bad Gateway")
+		}
+		b.edits++
+	}
+	return tgbotapi.Message{}, nil
+}
+
+func (b *fakeBot) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	if answer, ok := c.(tgbotapi.CallbackConfig); ok {
+		b.answers = append(b.answers, answer.Text)
+	}
+	return &tgbotapi.APIResponse{Ok: true}, nil
+}
+
+// fakePoster makes posted calls right away.
+type fakePoster struct {
+	*fakeBot
+}
+
+func (p fakePoster) Post(c tgbotapi.Chattable, done func(msg tgbotapi.Message, err error)) {
+	p.posts++
+	done(p.Send(c))
+}
+
+func press(rt *Router, data string) {
+	rt.Dispatch(tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:      "1",
+		Data:    data,
+		Message: &tgbotapi.Message{MessageID: 10, Chat: &tgbotapi.Chat{ID: 1}},
+	}})
+}
+
+func TestFailedEditIsRetried(t *testing.T) {
+	bot := &fakeBot{}
+	for name, b := range map[string]Bot{"Send": bot, "Post": fakePoster{bot}} {
+		*bot = fakeBot{failEdits: 1}
+		rt := NewRouter(b)
+		rt.Screen(Screen{
+			Name: "main",
+			Render: func(r *Request) (string, *tgbotapi.InlineKeyboardMarkup) {
+				return "Main menu", nil
+			},
+		})
+		data, err := Encode(Payload{Screen: "main"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		press(rt, data) // Fails, the menu still shows whatever it showed before
+		press(rt, data)
+		if bot.edits != 1 {
+			t.Errorf("%s: %d edits after a failed one, want 1", name, bot.edits)
+		}
+		press(rt, data)
+		if bot.edits != 1 || bot.answers[len(bot.answers)-1] != rt.Unchanged {
+			t.Errorf("%s: press showing the same menu made %d edits and was answered %q",
+				name, bot.edits, bot.answers[len(bot.answers)-1])
+		}
+	}
+}
+
+func TestRepliesArePosted(t *testing.T) {
+	bot := &fakeBot{}
+	rt := NewRouter(fakePoster{bot})
+	var sent int
+	rt.Command(Command{
+		Name: "start",
+		Handle: func(r *Request) error {
+			r.Text = "Hello"
+			r.OnSent(func(msg tgbotapi.Message) { sent++ })
+			return nil
+		},
+	})
+	rt.Dispatch(tgbotapi.Update{Message: &tgbotapi.Message{
+		Text:     "/start",
+		Chat:     &tgbotapi.Chat{ID: 1},
+		Entities: []tgbotapi.MessageEntity{{Type: "bot_command", Length: len("/start")}},
+	}})
+	if bot.posts != 1 || sent != 1 {
+		t.Errorf("reply posted %d times and OnSent ran %d times, want once each", bot.posts, sent)
+	}
+}
+
+func TestRenderedIsBounded(t *testing.T) {
+	start := time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)
+	rt := NewRouter(&fakeBot{})
+	for i := 0; i < maxRendered; i++ {
+		rt.remember(messageKey{int64(i), 1}, 1, start.Add(time.Duration(i)*time.Second))
+	}
+
+	// Menus of the last day are kept, only the older half of them makes room
+	rt.remember(messageKey{-1, 1}, 1, start.Add(time.Duration(maxRendered)*time.Second))
+	if n := len(rt.rendered); n > maxRendered/2+1 {
+		t.Errorf("%d menus remembered, want at most %d", n, maxRendered/2+1)
+	}
+	if _, ok := rt.rendered[messageKey{maxRendered - 1, 1}]; !ok {
+		t.Errorf("the latest menu got forgotten")
+	}
+
+	// Menus untouched for renderedTTL are forgotten first
+	for i := len(rt.rendered); i < maxRendered; i++ {
+		rt.remember(messageKey{int64(maxRendered + i), 1}, 1, start)
+	}
+	rt.remember(messageKey{-2, 1}, 1, start.Add(renderedTTL+time.Hour))
+	if n := len(rt.rendered); n > maxRendered/2+2 {
+		t.Errorf("%d menus remembered after a day, want at most %d", n, maxRendered/2+2)
+	}
+}
@@ -0,0 +1,129 @@
+package menu
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version of the callback data encoding. Bump it whenever screens or their actions change
+// incompatibly, buttons of menus sent before are then answered as expired instead of being
+// misread.
+const Version = 1
+
+// MaxDataLen is the Telegram limit of callback data, in bytes.
+const MaxDataLen = 64
+
+// ErrExpired is returned for callback data from an older version of the menus or data which
+// does not decode at all.
+var ErrExpired = errors.New("menu expired")
+
+const (
+	sep       = "|"
+	intTag    = 'i'
+	stringTag = 's'
+)
+
+// Payload is the callback data of a button: the screen it belongs to, an optional action for the
+// screen to apply and the action's arguments. Arguments are ints or strings.
+type Payload struct {
+	Screen string
+	Action string
+	Args   []any
+}
+
+// Int returns argument i as an int.
+func (p Payload) Int(i int) (int, error) {
+	if i >= len(p.Args) {
+		return 0, fmt.Errorf("%s: no argument %d", p.Action, i)
+	}
+	n, ok := p.Args[i].(int)
+	if !ok {
+		return 0, fmt.Errorf("%s: argument %d is %T, not int", p.Action, i, p.Args[i])
+	}
+	return n, nil
+}
+
+// Str returns argument i as a string.
+func (p Payload) Str(i int) (string, error) {
+	if i >= len(p.Args) {
+		return "", fmt.Errorf("%s: no argument %d", p.Action, i)
+	}
+	s, ok := p.Args[i].(string)
+	if !ok {
+		return "", fmt.Errorf("%s: argument %d is %T, not string", p.Action, i, p.Args[i])
+	}
+	return s, nil
+}
+
+// Encode packs the payload into callback data: the version digit followed by the screen, the
+// action and the tagged arguments separated by "|", e.g. "1diabler-settings-alarm|add|ia" for
+// adding a 10 minute alarm. Ints are written in base 36 to save space.
+func Encode(p Payload) (string, error) {
+	if strings.Contains(p.Screen+p.Action, sep) {
+		return "", fmt.Errorf("screen %q or action %q contains %q", p.Screen, p.Action, sep)
+	}
+	var b strings.Builder
+	b.WriteString(strconv.Itoa(Version))
+	b.WriteString(p.Screen)
+	if p.Action != "" || len(p.Args) > 0 {
+		b.WriteString(sep + p.Action)
+	}
+	for i, arg := range p.Args {
+		b.WriteString(sep)
+		switch v := arg.(type) {
+		case int:
+			b.WriteByte(intTag)
+			b.WriteString(strconv.FormatInt(int64(v), 36))
+		case string:
+			if strings.Contains(v, sep) {
+				return "", fmt.Errorf("argument %d %q contains %q", i, v, sep)
+			}
+			b.WriteByte(stringTag)
+			b.WriteString(v)
+		default:
+			return "", fmt.Errorf("argument %d is %T, only int and string are supported", i, arg)
+		}
+	}
+	if b.Len() > MaxDataLen {
+		return "", fmt.Errorf("%q is %d bytes, over the limit of %d", b.String(), b.Len(), MaxDataLen)
+	}
+	return b.String(), nil
+}
+
+// Decode unpacks callback data made by Encode. Data of another version or not made by Encode is
+// ErrExpired.
+func Decode(data string) (p Payload, err error) {
+	version := strconv.Itoa(Version)
+	if !strings.HasPrefix(data, version) {
+		return p, fmt.Errorf("%w: not version %s", ErrExpired, version)
+	}
+	fields := strings.Split(strings.TrimPrefix(data, version), sep)
+	p.Screen = fields[0]
+	if p.Screen == "" {
+		return p, fmt.Errorf("%w: no screen", ErrExpired)
+	}
+	var args []string
+	if len(fields) > 1 {
+		p.Action, args = fields[1], fields[2:]
+	}
+	for i, field := range args {
+		if field == "" {
+			return p, fmt.Errorf("%w: argument %d is empty", ErrExpired, i)
+		}
+		switch field[0] {
+		case intTag:
+			n, err := strconv.ParseInt(field[1:], 36, 0)
+			if err != nil {
+				return p, fmt.Errorf("%w: argument %d: %s", ErrExpired, i, err)
+			}
+			p.Args = append(p.Args, int(n))
+		case stringTag:
+			p.Args = append(p.Args, field[1:])
+		default:
+			return p, fmt.Errorf("%w: argument %d has unknown type %q", ErrExpired, i, field[0])
+		}
+	}
+	return p, nil
+}
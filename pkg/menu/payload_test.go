@@ -0,0 +1,84 @@
+package menu
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPayloadRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		p    Payload
+	}{
+		{"screen only", Payload{Screen: "diabler"}},
+		{"action without args", Payload{Screen: "diabler-settings", Action: "back"}},
+		{"int", Payload{Screen: "diabler-settings-alarm", Action: "add", Args: []any{10}}},
+		{"zero int", Payload{Screen: "diabler-settings-alarm", Action: "add", Args: []any{0}}},
+		{"negative int", Payload{Screen: "diabler-settings-tz", Action: "set", Args: []any{-330}}},
+		{"string", Payload{Screen: "diabler-settings-tz", Action: "set", Args: []any{"America/St_Johns"}}},
+		{"empty string", Payload{Screen: "diabler-settings-tz", Action: "set", Args: []any{""}}},
+		{"mixed args", Payload{Screen: "diabler-wb", Action: "mute", Args: []any{"Ashava", 3, ""}}},
+		{"args without action", Payload{Screen: "diabler-wb", Args: []any{1}}},
+	}
+	for _, tt := range tests {
+		data, err := Encode(tt.p)
+		if err != nil {
+			t.Errorf("%s: Encode(%+v): %s", tt.name, tt.p, err)
+			continue
+		}
+		got, err := Decode(data)
+		if err != nil {
+			t.Errorf("%s: Decode(%q): %s", tt.name, data, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.p) {
+			t.Errorf("%s: Decode(Encode()) = %+v, want %+v", tt.name, got, tt.p)
+		}
+	}
+}
+
+func TestEncodeLimits(t *testing.T) {
+	// The version digit and the screen fill the limit exactly
+	fits := Payload{Screen: strings.Repeat("a", MaxDataLen-1)}
+	if data, err := Encode(fits); err != nil || len(data) != MaxDataLen {
+		t.Errorf("Encode() of %d bytes = %q, %v, want it to fit", MaxDataLen, data, err)
+	}
+	tests := []struct {
+		name string
+		p    Payload
+	}{
+		{"over the limit", Payload{Screen: strings.Repeat("a", MaxDataLen)}},
+		{"long string arg", Payload{Screen: "diabler", Action: "set", Args: []any{strings.Repeat("a", MaxDataLen)}}},
+		{"separator in screen", Payload{Screen: "diabler" + sep + "settings"}},
+		{"separator in action", Payload{Screen: "diabler", Action: "a" + sep + "b"}},
+		{"separator in arg", Payload{Screen: "diabler", Action: "set", Args: []any{"Europe" + sep + "Berlin"}}},
+		{"unsupported arg", Payload{Screen: "diabler", Action: "set", Args: []any{1.5}}},
+	}
+	for _, tt := range tests {
+		if data, err := Encode(tt.p); err == nil {
+			t.Errorf("%s: Encode(%+v) = %q, want an error", tt.name, tt.p, data)
+		}
+	}
+}
+
+func TestDecodeExpired(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"legacy data", "diabler-settings-alarm-increase-30m"},
+		{"another version", "2diabler-settings-alarm|add|ia"},
+		{"empty", ""},
+		{"no screen", "1|add|ia"},
+		{"empty arg", "1diabler-settings-alarm|add|"},
+		{"untagged arg", "1diabler-settings-alarm|add|10"},
+		{"bad int", "1diabler-settings-alarm|add|i!"},
+	}
+	for _, tt := range tests {
+		if p, err := Decode(tt.data); !errors.Is(err, ErrExpired) {
+			t.Errorf("%s: Decode(%q) = %+v, %v, want ErrExpired", tt.name, tt.data, p, err)
+		}
+	}
+}
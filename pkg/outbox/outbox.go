@@ -0,0 +1,276 @@
+// Package outbox queues outgoing Telegram API calls so the bot stays within the flood limits:
+// about 30 messages a second overall and one a second per chat, fewer in groups. Calls refused
+// with "Too Many Requests" wait out retry_after, transient failures are retried and repeated
+// edits of a message still waiting in the queue are merged into the latest one.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/tetra5/diabler/pkg/clock"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	globalInterval = time.Second / 30
+	chatInterval   = time.Second
+	groupInterval  = 3 * time.Second // 20 messages a minute
+	maxAttempts    = 4
+	retryDelay     = time.Second // Doubled on every attempt
+)
+
+// ErrStopped is returned for calls still queued when the queue stops.
+var ErrStopped = errors.New("outbox stopped")
+
+// Bot is the part of tgbotapi.BotAPI the queue calls.
+type Bot interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
+}
+
+// Queue sends API calls one at a time from Run. Send and Request block until the call is done,
+// Post does not wait. It implements Bot itself so it can stand in for the bot.
+type Queue struct {
+	Clock clock.Clock
+	bot   Bot
+
+	mu      sync.Mutex
+	items   []*item
+	chats   map[int64]time.Time // Earliest next call to each chat
+	global  time.Time           // Earliest next call at all
+	stopped bool
+	wake    chan struct{}
+}
+
+type item struct {
+	c         tgbotapi.Chattable
+	chatID    int64 // 0 for calls not sent to a chat, such as callback query answers
+	edit      editKey
+	request   bool
+	attempts  int
+	notBefore time.Time
+	waiters   []chan result
+}
+
+// editKey identifies the message an edit changes.
+type editKey struct {
+	chatID    int64
+	messageID int
+}
+
+type result struct {
+	msg  tgbotapi.Message
+	resp *tgbotapi.APIResponse
+	err  error
+}
+
+func New(bot Bot) *Queue {
+	return &Queue{
+		Clock: clock.Real{},
+		bot:   bot,
+		chats: map[int64]time.Time{},
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+// Send queues c and waits for it to be sent.
+func (q *Queue) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	res := <-q.enqueue(c, false, true)
+	return res.msg, res.err
+}
+
+// Request queues c, a call without a message as its result, and waits for it to be made.
+func (q *Queue) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	res := <-q.enqueue(c, true, true)
+	return res.resp, res.err
+}
+
+// Post queues c without waiting for it. done, unless nil, gets the result once the call is made
+// or given up on, failures are logged otherwise. It is called from another goroutine.
+func (q *Queue) Post(c tgbotapi.Chattable, done func(msg tgbotapi.Message, err error)) {
+	if done == nil {
+		q.enqueue(c, false, false)
+		return
+	}
+	res := q.enqueue(c, false, true)
+	go func() {
+		r := <-res
+		done(r.msg, r.err)
+	}()
+}
+
+func (q *Queue) enqueue(c tgbotapi.Chattable, request bool, wait bool) <-chan result {
+	done := make(chan result, 1)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stopped {
+		done <- result{err: ErrStopped}
+		return done
+	}
+	it := &item{c: c, request: request}
+	switch c := c.(type) {
+	case tgbotapi.MessageConfig:
+		it.chatID = c.ChatID
+	case tgbotapi.EditMessageTextConfig:
+		it.chatID = c.ChatID
+		it.edit = editKey{c.ChatID, c.MessageID}
+	}
+	if wait {
+		it.waiters = append(it.waiters, done)
+	}
+	if it.edit != (editKey{}) {
+		for _, queued := range q.items {
+			if queued.edit == it.edit {
+				// Only the latest state of the message matters, whoever waits for an earlier
+				// edit gets the result of this one.
+				queued.c = c
+				queued.waiters = append(queued.waiters, it.waiters...)
+				return done
+			}
+		}
+	}
+	q.items = append(q.items, it)
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return done
+}
+
+// Run sends queued calls until ctx is done, calls still queued then fail with ErrStopped.
+func (q *Queue) Run(ctx context.Context) {
+	for {
+		it, wait := q.next()
+		if it != nil {
+			q.call(it)
+			continue
+		}
+		var after <-chan time.Time
+		if wait > 0 {
+			after = q.Clock.After(wait)
+		}
+		select {
+		case <-ctx.Done():
+			q.stop()
+			return
+		case <-q.wake:
+		case <-after:
+		}
+	}
+}
+
+// next takes the first call which may be made now off the queue, otherwise it reports how long
+// to wait for one. A zero wait with no call means the queue is empty.
+func (q *Queue) next() (*item, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.Clock.Now()
+	for id, t := range q.chats {
+		if !t.After(now) {
+			delete(q.chats, id)
+		}
+	}
+	if q.global.After(now) {
+		return nil, q.global.Sub(now)
+	}
+	var wait time.Duration
+	for i, it := range q.items {
+		ready := it.notBefore
+		if t := q.chats[it.chatID]; it.chatID != 0 && t.After(ready) {
+			ready = t
+		}
+		if !ready.After(now) {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			q.global = now.Add(globalInterval)
+			if it.chatID != 0 {
+				q.chats[it.chatID] = now.Add(interval(it.chatID))
+			}
+			return it, 0
+		}
+		if d := ready.Sub(now); wait == 0 || d < wait {
+			wait = d
+		}
+	}
+	return nil, wait
+}
+
+// call makes the API call, it is queued again if it may succeed later.
+func (q *Queue) call(it *item) {
+	var res result
+	if it.request {
+		res.resp, res.err = q.bot.Request(it.c)
+	} else {
+		res.msg, res.err = q.bot.Send(it.c)
+	}
+	it.attempts++
+	if res.err != nil && it.attempts < maxAttempts {
+		now := q.Clock.Now()
+		var tgErr *tgbotapi.Error
+		switch {
+		case errors.As(res.err, &tgErr) && tgErr.Code == 429:
+			retryAfter := time.Duration(tgErr.RetryAfter) * time.Second
+			log.Printf("Flood limit hit sending %T to %d, retrying after %s", it.c, it.chatID, retryAfter)
+			q.retry(it, now.Add(retryAfter), true)
+			return
+		case !errors.As(res.err, &tgErr) || tgErr.Code >= 500:
+			q.retry(it, now.Add(retryDelay<<(it.attempts-1)), false)
+			return
+		}
+	}
+	q.finish(it, res)
+}
+
+// retry queues the call again at the front, a flood limit holds back the whole chat.
+func (q *Queue) retry(it *item, at time.Time, flood bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stopped {
+		q.finishLocked(it, result{err: ErrStopped})
+		return
+	}
+	it.notBefore = at
+	if flood && it.chatID != 0 {
+		q.chats[it.chatID] = at
+	} else if flood {
+		q.global = at
+	}
+	q.items = append([]*item{it}, q.items...)
+}
+
+func (q *Queue) finish(it *item, res result) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.finishLocked(it, res)
+}
+
+func (q *Queue) finishLocked(it *item, res result) {
+	if res.err != nil && len(it.waiters) == 0 {
+		log.Printf("Error sending %T to %d: %s", it.c, it.chatID, res.err)
+	}
+	for _, w := range it.waiters {
+		w <- res
+	}
+}
+
+func (q *Queue) stop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.stopped = true
+	for _, it := range q.items {
+		q.finishLocked(it, result{err: ErrStopped})
+	}
+	q.items = nil
+}
+
+// interval is the minimum time between messages to a chat, group chats have negative IDs.
+func interval(chatID int64) time.Duration {
+	if chatID < 0 {
+		return groupInterval
+	}
+	return chatInterval
+}
@@ -0,0 +1,198 @@
+package outbox
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tetra5/diabler/pkg/clock"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var testStart = time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)
+
+// fakeBot records the calls made along with the time of the clock and fails the ones errs holds,
+// in order.
+type fakeBot struct {
+	clock *clock.Fake
+	errs  []error
+	calls []call
+}
+
+type call struct {
+	c  tgbotapi.Chattable
+	at time.Duration // Since testStart
+}
+
+func (b *fakeBot) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	b.calls = append(b.calls, call{c, b.clock.Now().Sub(testStart)})
+	if len(b.errs) > 0 {
+		err := b.errs[0]
+		b.errs = b.errs[1:]
+		return tgbotapi.Message{}, err
+	}
+	return tgbotapi.Message{MessageID: len(b.calls)}, nil
+}
+
+func (b *fakeBot) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	_, err := b.Send(c)
+	return &tgbotapi.APIResponse{Ok: err == nil}, err
+}
+
+func newTestQueue() (*Queue, *fakeBot) {
+	fc := clock.NewFake(testStart)
+	bot := &fakeBot{clock: fc}
+	q := New(bot)
+	q.Clock = fc
+	return q, bot
+}
+
+// drain makes the queued calls the way Run does, moving the clock along instead of waiting.
+func drain(q *Queue) {
+	fc := q.Clock.(*clock.Fake)
+	for {
+		it, wait := q.next()
+		if it != nil {
+			q.call(it)
+			continue
+		}
+		if wait == 0 {
+			return
+		}
+		fc.Advance(wait)
+	}
+}
+
+func chatOf(c tgbotapi.Chattable) int64 {
+	switch c := c.(type) {
+	case tgbotapi.MessageConfig:
+		return c.ChatID
+	case tgbotapi.EditMessageTextConfig:
+		return c.ChatID
+	}
+	return 0
+}
+
+func TestSpacing(t *testing.T) {
+	q, bot := newTestQueue()
+	q.Post(tgbotapi.NewMessage(1, "a"), nil)
+	q.Post(tgbotapi.NewMessage(1, "b"), nil)
+	q.Post(tgbotapi.NewMessage(2, "c"), nil)
+	q.Post(tgbotapi.NewMessage(-5, "d"), nil)
+	q.Post(tgbotapi.NewMessage(-5, "e"), nil)
+	drain(q)
+
+	// Another chat goes right after the global interval, the same chat waits for its own one
+	want := []struct {
+		chatID int64
+		at     time.Duration
+	}{
+		{1, 0},
+		{2, globalInterval},
+		{-5, 2 * globalInterval},
+		{1, chatInterval},
+		{-5, 2*globalInterval + groupInterval},
+	}
+	if len(bot.calls) != len(want) {
+		t.Fatalf("%d calls made, want %d", len(bot.calls), len(want))
+	}
+	for i, w := range want {
+		if got := bot.calls[i]; chatOf(got.c) != w.chatID || got.at != w.at {
+			t.Errorf("call %d went to %d at %s, want %d at %s", i, chatOf(got.c), got.at, w.chatID, w.at)
+		}
+	}
+}
+
+func TestFloodLimit(t *testing.T) {
+	q, bot := newTestQueue()
+	bot.errs = []error{&tgbotapi.Error{
+		Code:               429,
+		Message:            "Too Many Requests: retry after 5",
+		ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 5},
+	}}
+	q.Post(tgbotapi.NewMessage(1, "a"), nil)
+	q.Post(tgbotapi.NewMessage(1, "b"), nil)
+	q.Post(tgbotapi.NewMessage(2, "c"), nil)
+	drain(q)
+
+	// The refused call is retried first once retry_after is over, other chats are not held back
+	want := []struct {
+		text string
+		at   time.Duration
+	}{
+		{"a", 0},
+		{"c", globalInterval},
+		{"a", 5 * time.Second},
+		{"b", 5*time.Second + chatInterval},
+	}
+	if len(bot.calls) != len(want) {
+		t.Fatalf("%d calls made, want %d", len(bot.calls), len(want))
+	}
+	for i, w := range want {
+		if got := bot.calls[i]; got.c.(tgbotapi.MessageConfig).Text != w.text || got.at != w.at {
+			t.Errorf("call %d sent %q at %s, want %q at %s",
+				i, got.c.(tgbotapi.MessageConfig).Text, got.at, w.text, w.at)
+		}
+	}
+}
+
+func TestEditsAreMerged(t *testing.T) {
+	q, bot := newTestQueue()
+	results := make(chan error, 3)
+	done := func(_ tgbotapi.Message, err error) { results <- err }
+	q.Post(tgbotapi.NewEditMessageText(1, 10, "first"), done)
+	q.Post(tgbotapi.NewEditMessageText(1, 11, "other"), done)
+	q.Post(tgbotapi.NewEditMessageText(1, 10, "second"), done)
+	drain(q)
+
+	if len(bot.calls) != 2 {
+		t.Fatalf("%d calls made, want 2", len(bot.calls))
+	}
+	if edit := bot.calls[0].c.(tgbotapi.EditMessageTextConfig); edit.MessageID != 10 || edit.Text != "second" {
+		t.Errorf("first call edits message %d to %q, want message 10 to %q", edit.MessageID, edit.Text, "second")
+	}
+	// Whoever posted the merged edit gets the result of the one sent
+	for i := 0; i < 3; i++ {
+		if err := <-results; err != nil {
+			t.Errorf("result %d: %s", i, err)
+		}
+	}
+}
+
+func TestGivesUp(t *testing.T) {
+	q, bot := newTestQueue()
+	for i := 0; i < maxAttempts; i++ {
+		bot.errs = append(bot.errs, errors.New("connection reset"))
+	}
+	results := make(chan error, 1)
+	q.Post(tgbotapi.NewMessage(1, "a"), func(_ tgbotapi.Message, err error) { results <- err })
+	drain(q)
+
+	if len(bot.calls) != maxAttempts {
+		t.Fatalf("%d calls made, want %d", len(bot.calls), maxAttempts)
+	}
+	// The delay doubles on every attempt
+	at := time.Duration(0)
+	for i, c := range bot.calls {
+		if c.at != at {
+			t.Errorf("attempt %d at %s, want %s", i+1, c.at, at)
+		}
+		at += retryDelay << i
+	}
+	if err := <-results; err == nil {
+		t.Error("result of a call failing every attempt is no error")
+	}
+
+	// Errors other than flood limits and server errors are not retried
+	q, bot = newTestQueue()
+	bot.errs = []error{&tgbotapi.Error{Code: 403, Message: "Forbidden: bot was blocked by the user"}}
+	q.Post(tgbotapi.NewMessage(1, "a"), func(_ tgbotapi.Message, err error) { results <- err })
+	drain(q)
+	if len(bot.calls) != 1 {
+		t.Errorf("%d calls made to a blocked chat, want 1", len(bot.calls))
+	}
+	if err := <-results; err == nil {
+		t.Error("result of a refused call is no error")
+	}
+}
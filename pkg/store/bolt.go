@@ -0,0 +1,269 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/tetra5/diabler/pkg/d4/events"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	usersBucket        = []byte("users")
+	observationsBucket = []byte("observations")
+	metaBucket         = []byte("meta")
+	quarantineBucket   = []byte("quarantine")
+	jobsBucket         = []byte("jobs")
+	schemaVersionKey   = []byte("schema_version")
+)
+
+// Bolt keeps every user in a separate record of an embedded bbolt database, keyed by chat ID.
+type Bolt struct {
+	db          *bolt.DB
+	quarantined []QuarantinedRecord
+}
+
+func OpenBolt(fPath string) (*Bolt, error) {
+	db, err := bolt.Open(fPath, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	b := &Bolt{db: db}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{usersBucket, observationsBucket, metaBucket, quarantineBucket, jobsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		if err := migrateBolt(tx); err != nil {
+			return err
+		}
+		b.quarantined, err = quarantineBolt(tx)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// migrateBolt upgrades every user record to SchemaVersion.
+func migrateBolt(tx *bolt.Tx) error {
+	meta := tx.Bucket(metaBucket)
+	users := tx.Bucket(usersBucket)
+	from := 0
+	if v := meta.Get(schemaVersionKey); v != nil {
+		from = int(binary.BigEndian.Uint64(v))
+	} else if k, _ := users.Cursor().First(); k == nil {
+		from = SchemaVersion // Fresh database
+	}
+	if from > SchemaVersion {
+		return fmt.Errorf("%w: %d, at most %d", errNewerSchema, from, SchemaVersion)
+	}
+	if from < SchemaVersion {
+		migrated := map[string][]byte{}
+		err := users.ForEach(func(k, v []byte) error {
+			m, err := migrateUser(v, from)
+			if err != nil {
+				return fmt.Errorf("user %x: %w", k, err)
+			}
+			migrated[string(k)] = m
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for k, v := range migrated {
+			if err := users.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+	}
+	return meta.Put(schemaVersionKey, boltKey(SchemaVersion))
+}
+
+// quarantineBolt moves user records failing validation to the quarantine bucket.
+func quarantineBolt(tx *bolt.Tx) (quarantined []QuarantinedRecord, err error) {
+	users := tx.Bucket(usersBucket)
+	var keys [][]byte
+	err = users.ForEach(func(k, v []byte) error {
+		u, err := decodeUser(v)
+		if err == nil && !bytes.Equal(k, boltKey(uint64(u.ChatID))) {
+			err = fmt.Errorf("key %x does not match chat ID %d", k, u.ChatID)
+		}
+		if err != nil {
+			keys = append(keys, append([]byte(nil), k...))
+			quarantined = append(quarantined, QuarantinedRecord{
+				Reason: err.Error(),
+				Record: append(json.RawMessage(nil), v...),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	quarantine := tx.Bucket(quarantineBucket)
+	for i, q := range quarantined {
+		seq, err := quarantine.NextSequence()
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(&q)
+		if err != nil {
+			return nil, err
+		}
+		if err := quarantine.Put(boltKey(seq), v); err != nil {
+			return nil, err
+		}
+		if err := users.Delete(keys[i]); err != nil {
+			return nil, err
+		}
+	}
+	return quarantined, nil
+}
+
+func boltKey(n uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, n)
+	return key
+}
+
+func (b *Bolt) GetUser(chatID int64) (u User, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(usersBucket).Get(boltKey(uint64(chatID)))
+		if v == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(v, &u)
+	})
+	return u, err
+}
+
+func (b *Bolt) PutUser(u User) error {
+	if err := u.Validate(); err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return putBoltUser(tx, u.ChatID, u)
+	})
+}
+
+func putBoltUser(tx *bolt.Tx, chatID int64, u User) error {
+	v, err := json.Marshal(&u)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(usersBucket).Put(boltKey(uint64(chatID)), v)
+}
+
+func (b *Bolt) UpdateUser(chatID int64, fn func(u *User) error) (u User, err error) {
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		v := tx.Bucket(usersBucket).Get(boltKey(uint64(chatID)))
+		if v == nil {
+			return ErrNotFound
+		}
+		if err := json.Unmarshal(v, &u); err != nil {
+			return err
+		}
+		if err := fn(&u); err != nil {
+			return err
+		}
+		if err := u.Validate(); err != nil {
+			return err
+		}
+		u.ChatID = chatID
+		return putBoltUser(tx, chatID, u)
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return u, nil
+}
+
+func (b *Bolt) Users() (users []User, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(usersBucket).ForEach(func(_, v []byte) error {
+			var u User
+			if err := json.Unmarshal(v, &u); err != nil {
+				return err
+			}
+			users = append(users, u)
+			return nil
+		})
+	})
+	return users, err
+}
+
+func (b *Bolt) Observations() (obs []events.Observation, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(observationsBucket).ForEach(func(_, v []byte) error {
+			var o events.Observation
+			if err := json.Unmarshal(v, &o); err != nil {
+				return err
+			}
+			obs = append(obs, o)
+			return nil
+		})
+	})
+	return obs, err
+}
+
+func (b *Bolt) AddObservation(o events.Observation) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(observationsBucket)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		v, err := json.Marshal(&o)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(boltKey(seq), v)
+	})
+}
+
+func (b *Bolt) Jobs() (jobs []Job, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(_, v []byte) error {
+			var j Job
+			if err := json.Unmarshal(v, &j); err != nil {
+				return err
+			}
+			jobs = append(jobs, j)
+			return nil
+		})
+	})
+	sortJobs(jobs)
+	return jobs, err
+}
+
+func (b *Bolt) PutJob(j Job) error {
+	v, err := json.Marshal(&j)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Put([]byte(j.ID()), v)
+	})
+}
+
+func (b *Bolt) DeleteJob(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Delete([]byte(id))
+	})
+}
+
+func (b *Bolt) Quarantined() []QuarantinedRecord {
+	return b.quarantined
+}
+
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	backupCount    = 3
+	backupInterval = time.Hour // A new backup is made at most this often
+)
+
+// backupPath returns path of the n-th backup of fPath, 1 being the most recent.
+func backupPath(fPath string, n int) string {
+	return fmt.Sprintf("%s.%d", fPath, n)
+}
+
+// writeFileAtomic replaces fPath with bytes so that a crash leaves either the old or the new
+// contents, never a truncated file.
+func writeFileAtomic(fPath string, bytes []byte) (err error) {
+	dir := filepath.Dir(fPath)
+	f, err := os.CreateTemp(dir, filepath.Base(fPath)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if _, err = f.Write(bytes); err != nil {
+		return err
+	}
+	if err = f.Chmod(0644); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(f.Name(), fPath); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// rotateBackups shifts existing backups by one, dropping the oldest, and backs fPath up as
+// the most recent one. Nothing happens if the most recent backup is fresh enough.
+func rotateBackups(fPath string, now time.Time) error {
+	if _, err := os.Stat(fPath); err != nil {
+		return nil // Nothing to back up yet
+	}
+	if fi, err := os.Stat(backupPath(fPath, 1)); err == nil && now.Sub(fi.ModTime()) < backupInterval {
+		return nil
+	}
+	for n := backupCount - 1; n >= 1; n-- {
+		err := os.Rename(backupPath(fPath, n), backupPath(fPath, n+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return copyFile(fPath, backupPath(fPath, 1))
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	bytes, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(dst, bytes)
+}
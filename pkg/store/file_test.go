@@ -0,0 +1,87 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	fPath := filepath.Join(dir, "diabler.json")
+	for _, contents := range []string{"first", "second"} {
+		if err := writeFileAtomic(fPath, []byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+		if data, err := os.ReadFile(fPath); err != nil || string(data) != contents {
+			t.Errorf("file holds %q, %v, want %q", data, err, contents)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("temporary files are left behind: %v", entries)
+	}
+	if err := writeFileAtomic(filepath.Join(dir, "missing", "diabler.json"), []byte("x")); err == nil {
+		t.Error("writeFileAtomic() into a missing directory succeeded")
+	}
+}
+
+func TestRotateBackups(t *testing.T) {
+	fPath := filepath.Join(t.TempDir(), "diabler.json")
+	now := time.Now()
+	if err := rotateBackups(fPath, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(backupPath(fPath, 1)); !os.IsNotExist(err) {
+		t.Fatalf("backup of a missing file was made: %v", err)
+	}
+
+	contents := func(n int) string {
+		data, _ := os.ReadFile(backupPath(fPath, n))
+		return string(data)
+	}
+	write := func(contents string) {
+		if err := os.WriteFile(fPath, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("1")
+	if err := rotateBackups(fPath, now); err != nil {
+		t.Fatal(err)
+	}
+	if contents(1) != "1" {
+		t.Fatalf("first backup holds %q, want \"1\"", contents(1))
+	}
+	// The latest backup is fresh, nothing is rotated until it is backupInterval old
+	write("2")
+	if err := rotateBackups(fPath, now); err != nil {
+		t.Fatal(err)
+	}
+	if contents(1) != "1" || contents(2) != "" {
+		t.Fatalf("fresh backup was rotated: %q, %q", contents(1), contents(2))
+	}
+
+	for i := 2; i <= backupCount+1; i++ {
+		write(fmt.Sprint(i))
+		old := now.Add(-backupInterval)
+		if err := os.Chtimes(backupPath(fPath, 1), old, old); err != nil {
+			t.Fatal(err)
+		}
+		if err := rotateBackups(fPath, now); err != nil {
+			t.Fatal(err)
+		}
+		for n := 1; n <= backupCount && n <= i; n++ {
+			if want := fmt.Sprint(i - n + 1); contents(n) != want {
+				t.Errorf("after %d backups backup %d holds %q, want %q", i, n, contents(n), want)
+			}
+		}
+	}
+	if _, err := os.Stat(backupPath(fPath, backupCount+1)); !os.IsNotExist(err) {
+		t.Errorf("more than %d backups are kept: %v", backupCount, err)
+	}
+}
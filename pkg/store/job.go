@@ -0,0 +1,38 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/tetra5/diabler/pkg/d4/events"
+)
+
+// Job is a pending alarm notification. Jobs are stored until delivered so alarms survive
+// restarts.
+type Job struct {
+	ChatID     int64       `json:"chat_id"`
+	Kind       events.Kind `json:"kind"`
+	EventStart time.Time   `json:"event_start"`
+	Title      string      `json:"title,omitempty"` // Boss name or event name
+	AlarmTimer int         `json:"alarm_timer"`     // Minutes before EventStart
+	Due        time.Time   `json:"due"`
+	Zone       string      `json:"zone,omitempty"` // Event location, empty if unknown
+	Text       string      `json:"text,omitempty"` // Digests only, alarms are rendered when sent
+	// ClaimedAt is set right before the notification is sent. A claimed job found on start-up
+	// means the process died while sending, there is no telling if it got through.
+	ClaimedAt time.Time `json:"claimed_at,omitempty"`
+	Attempts  int       `json:"attempts,omitempty"`
+}
+
+// ID identifies the notification, putting a job with the same ID again replaces the pending one
+// so a notification is never queued twice.
+func (j *Job) ID() string {
+	return fmt.Sprintf("%d/%s/%d/%d", j.ChatID, j.Kind, j.EventStart.Unix(), j.AlarmTimer)
+}
+
+func sortJobs(jobs []Job) {
+	sort.SliceStable(jobs, func(a, b int) bool {
+		return jobs[a].Due.Before(jobs[b].Due)
+	})
+}
@@ -0,0 +1,246 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tetra5/diabler/pkg/d4/events"
+)
+
+// JSON keeps everything in memory and rewrites the whole file on every change. It is fine for
+// a few hundred chats, use Bolt beyond that. The file is replaced atomically and backed up
+// regularly, see OpenJSON for recovery.
+type JSON struct {
+	*Memory
+	RecoveredFrom string // Backup the data was loaded from if the file itself was unreadable
+	LoadedVersion int    // Schema version of the loaded file, it is migrated if older than SchemaVersion
+	fPath         string
+	mu            sync.Mutex          // Serializes changes so the file always reflects the latest state
+	quarantine    []QuarantinedRecord // Every quarantined record, including ones from earlier runs
+}
+
+// jsonData is the layout of the data file:
+//
+//	{
+//		"schema_version": 5,
+//		"users": [
+//			{
+//				"chat_id": 123456789,
+//				"time_zone": "Europe/Berlin",
+//				"wb_alarms": [30, 5, 0]
+//			}
+//		],
+//		"observations": [],
+//		"jobs": [],
+//		"quarantine": []
+//	}
+//
+// Older layouts are upgraded on load, see migrations.
+type jsonData struct {
+	SchemaVersion int                  `json:"schema_version"`
+	Users         []json.RawMessage    `json:"users"`
+	Observations  []events.Observation `json:"observations,omitempty"` // World Boss spawn reports
+	Jobs          []Job                `json:"jobs,omitempty"`         // Pending alarms
+	Quarantine    []QuarantinedRecord  `json:"quarantine,omitempty"`   // Corrupt user records
+}
+
+// OpenJSON loads the file at fPath. If the file is missing, empty or corrupt while there are
+// backups, the most recent readable backup is loaded instead and the broken file is kept aside
+// with a ".corrupt" suffix. A missing file without backups makes an empty store. A file of a
+// newer schema version is an error, backups are not tried.
+func OpenJSON(fPath string) (*JSON, error) {
+	js := &JSON{Memory: NewMemory(), fPath: fPath, LoadedVersion: SchemaVersion}
+	data, from, err := readJSONData(fPath)
+	if err == nil {
+		return js, js.load(fPath, data, from)
+	}
+	if errors.Is(err, errNewerSchema) {
+		return nil, fmt.Errorf("%s: %w", fPath, err)
+	}
+	errs := []error{fmt.Errorf("%s: %w", fPath, err)}
+	for n := 1; n <= backupCount; n++ {
+		data, from, err := readJSONData(backupPath(fPath, n))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if errors.Is(err, errNewerSchema) {
+			return nil, errors.Join(append(errs, fmt.Errorf("%s: %w", backupPath(fPath, n), err))...)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", backupPath(fPath, n), err))
+			continue
+		}
+		if _, err := os.Stat(fPath); err == nil {
+			if err := os.Rename(fPath, fPath+".corrupt"); err != nil {
+				return nil, err
+			}
+		}
+		js.RecoveredFrom = backupPath(fPath, n)
+		return js, js.load(backupPath(fPath, n), data, from)
+	}
+	if len(errs) == 1 && (errors.Is(err, errEmptyFile) || errors.Is(err, fs.ErrNotExist)) {
+		return js, nil // Nothing to recover, this is a fresh start
+	}
+	return nil, errors.Join(errs...)
+}
+
+var errEmptyFile = errors.New("file is empty")
+
+// readJSONData reads a data file of any schema version, from is the version it had.
+func readJSONData(fPath string) (data jsonData, from int, err error) {
+	bytes, err := os.ReadFile(fPath)
+	if err != nil {
+		return data, from, err
+	}
+	if len(bytes) == 0 {
+		return data, from, errEmptyFile
+	}
+	bytes, from, err = migrateJSON(bytes)
+	if err != nil {
+		return data, from, err
+	}
+	err = json.Unmarshal(bytes, &data)
+	return data, from, err
+}
+
+// load takes data read from srcPath. User records failing validation are quarantined. Migrated
+// data is saved right away, the file it was migrated from is kept with a ".v<version>" suffix.
+func (js *JSON) load(srcPath string, data jsonData, from int) error {
+	js.LoadedVersion = from
+	js.quarantine = data.Quarantine
+	for _, raw := range data.Users {
+		u, err := decodeUser(raw)
+		if err == nil {
+			if _, ok := js.users[u.ChatID]; ok {
+				err = fmt.Errorf("duplicate chat ID %d", u.ChatID) // The first record wins
+			}
+		}
+		if err != nil {
+			q := QuarantinedRecord{Reason: err.Error(), Record: raw}
+			js.quarantined = append(js.quarantined, q)
+			js.quarantine = append(js.quarantine, q)
+			continue
+		}
+		js.put(u)
+	}
+	js.observations = data.Observations
+	for _, j := range data.Jobs {
+		js.jobs[j.ID()] = j
+	}
+	if from == SchemaVersion {
+		if len(js.quarantined) > 0 {
+			return js.save()
+		}
+		return nil
+	}
+	if err := copyFile(srcPath, fmt.Sprintf("%s.v%d", js.fPath, from)); err != nil {
+		return err
+	}
+	return js.save()
+}
+
+// The methods changing data change it in memory first and undo that if the file cannot be saved,
+// so memory never runs ahead of the file.
+
+func (js *JSON) PutUser(u User) error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	old, errOld := js.Memory.GetUser(u.ChatID)
+	if err := js.Memory.PutUser(u); err != nil {
+		return err
+	}
+	if err := js.save(); err != nil {
+		js.restoreUser(u.ChatID, old, errOld == nil)
+		return err
+	}
+	return nil
+}
+
+func (js *JSON) UpdateUser(chatID int64, fn func(u *User) error) (User, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	old, errOld := js.Memory.GetUser(chatID)
+	u, err := js.Memory.UpdateUser(chatID, fn)
+	if err != nil {
+		return u, err
+	}
+	if err := js.save(); err != nil {
+		js.restoreUser(chatID, old, errOld == nil)
+		return User{}, err
+	}
+	return u, nil
+}
+
+func (js *JSON) AddObservation(o events.Observation) error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	old, _ := js.Memory.Observations()
+	if err := js.Memory.AddObservation(o); err != nil {
+		return err
+	}
+	if err := js.save(); err != nil {
+		js.truncateObservations(len(old))
+		return err
+	}
+	return nil
+}
+
+func (js *JSON) PutJob(j Job) error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	old, ok := js.job(j.ID())
+	if err := js.Memory.PutJob(j); err != nil {
+		return err
+	}
+	if err := js.save(); err != nil {
+		js.restoreJob(j.ID(), old, ok)
+		return err
+	}
+	return nil
+}
+
+func (js *JSON) DeleteJob(id string) error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	old, ok := js.job(id)
+	if err := js.Memory.DeleteJob(id); err != nil {
+		return err
+	}
+	if err := js.save(); err != nil {
+		js.restoreJob(id, old, ok)
+		return err
+	}
+	return nil
+}
+
+func (js *JSON) save() error {
+	users, _ := js.Memory.Users()
+	observations, _ := js.Memory.Observations()
+	jobs, _ := js.Memory.Jobs()
+	data := jsonData{
+		SchemaVersion: SchemaVersion,
+		Observations:  observations,
+		Jobs:          jobs,
+		Quarantine:    js.quarantine,
+	}
+	for _, u := range users {
+		raw, err := json.Marshal(&u)
+		if err != nil {
+			return err
+		}
+		data.Users = append(data.Users, raw)
+	}
+	bytes, err := json.Marshal(&data)
+	if err != nil {
+		return err
+	}
+	if err := rotateBackups(js.fPath, time.Now()); err != nil {
+		return err
+	}
+	return writeFileAtomic(js.fPath, bytes)
+}
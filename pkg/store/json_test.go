@@ -0,0 +1,117 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tetra5/diabler/pkg/d4/events"
+)
+
+// TestJSONRollsBackFailedSaves checks a change which could not be saved is undone in memory too,
+// otherwise it would be lost on restart while the bot keeps acting on it.
+func TestJSONRollsBackFailedSaves(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	js, err := OpenJSON(filepath.Join(dir, "diabler.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := NewUser(1)
+	u.WBAlarms = []int{10}
+	if err := js.PutUser(u); err != nil {
+		t.Fatal(err)
+	}
+	j := Job{ChatID: 1, Kind: events.KindWorldBoss, EventStart: time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC), AlarmTimer: 10}
+	if err := js.PutJob(j); err != nil {
+		t.Fatal(err)
+	}
+
+	// Saving fails from now on, the file can no longer be written
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := js.PutUser(NewUser(2)); err == nil {
+		t.Fatal("PutUser() saved without a directory")
+	}
+	if _, err := js.GetUser(2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetUser() of the user which failed to save = %v, want ErrNotFound", err)
+	}
+	if users, _ := js.Users(); len(users) != 1 {
+		t.Errorf("Users() = %v, want only the saved one", users)
+	}
+	_, err = js.UpdateUser(1, func(u *User) error {
+		u.WBAlarms = []int{30, 5}
+		return nil
+	})
+	if err == nil {
+		t.Fatal("UpdateUser() saved without a directory")
+	}
+	if got, _ := js.GetUser(1); len(got.WBAlarms) != 1 || got.WBAlarms[0] != 10 {
+		t.Errorf("alarms after a failed UpdateUser() = %v, want [10]", got.WBAlarms)
+	}
+	if err := js.PutJob(Job{ChatID: 1, Kind: events.KindWorldBoss, AlarmTimer: 5}); err == nil {
+		t.Fatal("PutJob() saved without a directory")
+	}
+	if err := js.DeleteJob(j.ID()); err == nil {
+		t.Fatal("DeleteJob() saved without a directory")
+	}
+	if jobs, _ := js.Jobs(); len(jobs) != 1 || jobs[0].ID() != j.ID() {
+		t.Errorf("Jobs() = %v, want only the saved one", jobs)
+	}
+	if err := js.AddObservation(events.Observation{Boss: "Ashava"}); err == nil {
+		t.Fatal("AddObservation() saved without a directory")
+	}
+	if obs, _ := js.Observations(); len(obs) != 0 {
+		t.Errorf("Observations() = %v, want none", obs)
+	}
+}
+
+func TestJSONRecoversFromBackup(t *testing.T) {
+	good := []byte(`{"schema_version": 4, "users": [{"chat_id": 2}]}`)
+	broken := map[string][]byte{
+		"empty":     {},
+		"truncated": []byte(`{"schema_version": 4, "users": [{"chat_`),
+	}
+	for name, data := range broken {
+		fPath := filepath.Join(t.TempDir(), "diabler.json")
+		if err := os.WriteFile(fPath, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(backupPath(fPath, 1), good, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		js, err := OpenJSON(fPath)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if js.RecoveredFrom != backupPath(fPath, 1) {
+			t.Errorf("%s: RecoveredFrom = %q, want %q", name, js.RecoveredFrom, backupPath(fPath, 1))
+		}
+		if _, err := js.GetUser(2); err != nil {
+			t.Errorf("%s: user of the backup: %s", name, err)
+		}
+		if kept, err := os.ReadFile(fPath + ".corrupt"); err != nil || string(kept) != string(data) {
+			t.Errorf("%s: broken file is not kept aside: %v", name, err)
+		}
+	}
+
+	// Without a readable backup a broken file is an error, an empty one a fresh start
+	fPath := filepath.Join(t.TempDir(), "diabler.json")
+	if err := os.WriteFile(fPath, broken["truncated"], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenJSON(fPath); err == nil {
+		t.Error("OpenJSON() of a broken file without backups succeeded")
+	}
+	if err := os.WriteFile(fPath, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenJSON(fPath); err != nil {
+		t.Errorf("OpenJSON() of an empty file without backups: %s", err)
+	}
+}
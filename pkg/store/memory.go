@@ -0,0 +1,172 @@
+package store
+
+import (
+	"sync"
+
+	"github.com/tetra5/diabler/pkg/d4/events"
+)
+
+// Memory keeps everything in memory indexed by chat ID, it is meant for tests and as a base
+// for other stores.
+type Memory struct {
+	mu           sync.Mutex
+	users        map[int64]User
+	order        []int64 // Chat IDs in insertion order
+	observations []events.Observation
+	jobs         map[string]Job
+	quarantined  []QuarantinedRecord
+}
+
+func NewMemory() *Memory {
+	return &Memory{users: map[int64]User{}, jobs: map[string]Job{}}
+}
+
+func (m *Memory) GetUser(chatID int64) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[chatID]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return u, nil
+}
+
+func (m *Memory) PutUser(u User) error {
+	if err := u.Validate(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.put(u)
+	return nil
+}
+
+func (m *Memory) put(u User) {
+	if _, ok := m.users[u.ChatID]; !ok {
+		m.order = append(m.order, u.ChatID)
+	}
+	m.users[u.ChatID] = u
+}
+
+// restoreUser undoes a change of the user, ok tells whether there was one before it.
+func (m *Memory) restoreUser(chatID int64, u User, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ok {
+		m.users[chatID] = u
+		return
+	}
+	if _, ok := m.users[chatID]; !ok {
+		return
+	}
+	delete(m.users, chatID)
+	for i, id := range m.order {
+		if id == chatID {
+			m.order = append(m.order[:i], m.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (m *Memory) UpdateUser(chatID int64, fn func(u *User) error) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[chatID]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	if err := fn(&u); err != nil {
+		return User{}, err
+	}
+	if err := u.Validate(); err != nil {
+		return User{}, err
+	}
+	u.ChatID = chatID
+	m.users[chatID] = u
+	return u, nil
+}
+
+func (m *Memory) Users() ([]User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	users := make([]User, 0, len(m.order))
+	for _, chatID := range m.order {
+		users = append(users, m.users[chatID])
+	}
+	return users, nil
+}
+
+func (m *Memory) Observations() ([]events.Observation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]events.Observation(nil), m.observations...), nil
+}
+
+func (m *Memory) AddObservation(o events.Observation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.observations = append(m.observations, o)
+	return nil
+}
+
+// truncateObservations drops the reports added after the first n.
+func (m *Memory) truncateObservations(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if n < len(m.observations) {
+		m.observations = m.observations[:n]
+	}
+}
+
+func (m *Memory) Jobs() ([]Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := make([]Job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		jobs = append(jobs, j)
+	}
+	sortJobs(jobs)
+	return jobs, nil
+}
+
+func (m *Memory) PutJob(j Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[j.ID()] = j
+	return nil
+}
+
+// restoreJob undoes a change of the job, ok tells whether there was one before it.
+func (m *Memory) restoreJob(id string, j Job, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ok {
+		m.jobs[id] = j
+	} else {
+		delete(m.jobs, id)
+	}
+}
+
+func (m *Memory) job(id string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	return j, ok
+}
+
+func (m *Memory) DeleteJob(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.jobs, id)
+	return nil
+}
+
+func (m *Memory) Quarantined() []QuarantinedRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]QuarantinedRecord(nil), m.quarantined...)
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
@@ -0,0 +1,164 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// SchemaVersion is the version of the data layout this package writes.
+const SchemaVersion = 5
+
+// errNewerSchema means the data was written by a newer version of the bot. It is not corrupt,
+// loading a backup instead would lose whatever changed since.
+var errNewerSchema = errors.New("schema version is newer than supported")
+
+// Migration upgrades stored data from Version-1 to Version. It works on decoded JSON so it does
+// not depend on the current Go types. Data runs first, then User runs for every user record;
+// either may be nil.
+type Migration struct {
+	Version     int
+	Description string
+	Data        func(doc map[string]any) error // The whole JSON file
+	User        func(u map[string]any) error   // A single user record
+}
+
+// Add new migrations to the end and bump SchemaVersion.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: `users are kept under "users" instead of "diabler", "wb_notify_period" is "wb_alarm_timer"`,
+		Data: func(doc map[string]any) error {
+			renameKey(doc, "diabler", "users")
+			return nil
+		},
+		User: func(u map[string]any) error {
+			renameKey(u, "wb_notify_period", "wb_alarm_timer")
+			return nil
+		},
+	},
+	{
+		Version:     2,
+		Description: `"chat_id" is a number instead of a string`,
+		User: func(u map[string]any) error {
+			if s, ok := u["chat_id"].(string); ok {
+				// Unparsable IDs are left as is and get quarantined on load
+				if chatID, err := strconv.ParseInt(s, 10, 64); err == nil {
+					u["chat_id"] = chatID
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     3,
+		Description: `"*_alarm_timer" is a list of lead times "*_alarms", "*_notified_on" is gone`,
+		User: func(u map[string]any) error {
+			for _, prefix := range []string{"wb", "ht", "lg"} {
+				if timer, ok := u[prefix+"_alarm_timer"].(float64); ok && timer > 0 {
+					u[prefix+"_alarms"] = []any{timer}
+				}
+				delete(u, prefix+"_alarm_timer")
+				delete(u, prefix+"_notified_on")
+			}
+			return nil
+		},
+	},
+	{
+		Version:     4,
+		Description: `"utc_offset" in hours is an IANA zone name "time_zone"`,
+		User: func(u map[string]any) error {
+			// Etc zones have their signs inverted: Etc/GMT-3 is UTC+3
+			if offset, ok := u["utc_offset"].(float64); ok && offset != 0 {
+				u["time_zone"] = fmt.Sprintf("Etc/GMT%+d", -int(offset))
+			}
+			delete(u, "utc_offset")
+			return nil
+		},
+	},
+	{
+		Version:     5,
+		Description: `"menu_message_id" is gone, it was never read`,
+		User: func(u map[string]any) error {
+			delete(u, "menu_message_id")
+			return nil
+		},
+	},
+}
+
+func init() {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			panic(fmt.Sprintf("store: migration #%d has version %d", i, m.Version))
+		}
+	}
+	if len(migrations) != SchemaVersion {
+		panic("store: SchemaVersion does not match migrations")
+	}
+}
+
+func renameKey(m map[string]any, from string, to string) {
+	v, ok := m[from]
+	if !ok {
+		return
+	}
+	if _, exists := m[to]; !exists {
+		m[to] = v
+	}
+	delete(m, from)
+}
+
+// migrateJSON upgrades a whole JSON data file, it reports the version the file had.
+func migrateJSON(bytes []byte) (migrated []byte, from int, err error) {
+	var doc map[string]any
+	if err := json.Unmarshal(bytes, &doc); err != nil {
+		return nil, 0, err
+	}
+	if v, ok := doc["schema_version"].(float64); ok {
+		from = int(v)
+	}
+	if from > SchemaVersion {
+		return nil, from, fmt.Errorf("%w: %d, at most %d", errNewerSchema, from, SchemaVersion)
+	}
+	if from == SchemaVersion {
+		return bytes, from, nil
+	}
+	for _, m := range migrations[from:] {
+		if m.Data != nil {
+			if err := m.Data(doc); err != nil {
+				return nil, from, fmt.Errorf("migration %d: %w", m.Version, err)
+			}
+		}
+		if m.User != nil {
+			users, _ := doc["users"].([]any)
+			for _, u := range users {
+				if u, ok := u.(map[string]any); ok {
+					if err := m.User(u); err != nil {
+						return nil, from, fmt.Errorf("migration %d: %w", m.Version, err)
+					}
+				}
+			}
+		}
+		doc["schema_version"] = m.Version
+	}
+	migrated, err = json.Marshal(doc)
+	return migrated, from, err
+}
+
+// migrateUser upgrades a single JSON encoded user record from version from.
+func migrateUser(bytes []byte, from int) ([]byte, error) {
+	var u map[string]any
+	if err := json.Unmarshal(bytes, &u); err != nil {
+		return nil, err
+	}
+	for _, m := range migrations[from:] {
+		if m.User == nil {
+			continue
+		}
+		if err := m.User(u); err != nil {
+			return nil, fmt.Errorf("migration %d: %w", m.Version, err)
+		}
+	}
+	return json.Marshal(u)
+}
@@ -0,0 +1,183 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// wantV0Users is testdata/v0.json after every migration, the record with a chat ID which is
+// not a number is quarantined.
+var wantV0Users = []User{
+	{ChatID: 123456789, TimeZone: "Etc/GMT-3", WBAlarms: []int{30}},
+	{ChatID: -100200300, TimeZone: "Etc/GMT+5"},
+	{ChatID: 555, WBAlarms: []int{15}},
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestMigrateJSON(t *testing.T) {
+	v0 := readFixture(t, "v0.json")
+	fPath := filepath.Join(t.TempDir(), "diabler.json")
+	if err := os.WriteFile(fPath, v0, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	js, err := OpenJSON(fPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if js.LoadedVersion != 0 {
+		t.Errorf("LoadedVersion = %d, want 0", js.LoadedVersion)
+	}
+	if users, _ := js.Users(); !reflect.DeepEqual(users, wantV0Users) {
+		t.Errorf("Users() = %+v, want %+v", users, wantV0Users)
+	}
+	if q := js.Quarantined(); len(q) != 1 || !bytes.Contains(q[0].Record, []byte("not a number")) {
+		t.Errorf("Quarantined() = %v, want the record with chat ID \"not a number\"", q)
+	}
+
+	// The file as it was before the migration is kept aside
+	if backup, err := os.ReadFile(fPath + ".v0"); err != nil || !bytes.Equal(backup, v0) {
+		t.Errorf("%s.v0 does not hold the original file: %v", fPath, err)
+	}
+	saved, err := os.ReadFile(fPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data jsonData
+	if err := json.Unmarshal(saved, &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.SchemaVersion != SchemaVersion || len(data.Users) != len(wantV0Users) {
+		t.Errorf("saved file has version %d and %d users, want %d and %d",
+			data.SchemaVersion, len(data.Users), SchemaVersion, len(wantV0Users))
+	}
+	if bytes.Contains(saved, []byte("menu_message_id")) {
+		t.Errorf("saved file still holds menu_message_id:\n%s", saved)
+	}
+
+	// Loading the migrated file again changes nothing
+	js, err = OpenJSON(fPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if js.LoadedVersion != SchemaVersion {
+		t.Errorf("LoadedVersion of the migrated file = %d, want %d", js.LoadedVersion, SchemaVersion)
+	}
+	if users, _ := js.Users(); !reflect.DeepEqual(users, wantV0Users) {
+		t.Errorf("Users() of the migrated file = %+v, want %+v", users, wantV0Users)
+	}
+}
+
+func TestMigrateJSONNewerVersion(t *testing.T) {
+	newer := []byte(`{"schema_version": 99, "users": [{"chat_id": 1}]}`)
+	backup := []byte(`{"schema_version": 4, "users": [{"chat_id": 2}]}`)
+	for _, withBackup := range []bool{false, true} {
+		fPath := filepath.Join(t.TempDir(), "diabler.json")
+		if err := os.WriteFile(fPath, newer, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if withBackup {
+			// An older backup must not be loaded instead, it lacks whatever changed since
+			if err := os.WriteFile(backupPath(fPath, 1), backup, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := OpenJSON(fPath); !errors.Is(err, errNewerSchema) {
+			t.Fatalf("OpenJSON() of a newer schema version, backup %v: %v, want errNewerSchema", withBackup, err)
+		}
+		// The file is left alone for the newer version which wrote it
+		if data, err := os.ReadFile(fPath); err != nil || !bytes.Equal(data, newer) {
+			t.Errorf("file of a newer schema version was changed, backup %v: %v", withBackup, err)
+		}
+		if _, err := os.Stat(fPath + ".corrupt"); err == nil {
+			t.Errorf("file of a newer schema version was moved aside as corrupt, backup %v", withBackup)
+		}
+	}
+}
+
+// writeBolt makes a database holding the given user records, keyed by their chat IDs. A zero
+// version stores none, the way databases looked before versions were stored.
+func writeBolt(t *testing.T, fPath string, users []json.RawMessage, version int) {
+	t.Helper()
+	db, err := bolt.Open(fPath, 0o644, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(usersBucket)
+		if err != nil {
+			return err
+		}
+		for _, raw := range users {
+			var u struct {
+				ChatID int64 `json:"chat_id,string"`
+			}
+			if err := json.Unmarshal(raw, &u); err != nil {
+				return err
+			}
+			if err := b.Put(boltKey(uint64(u.ChatID)), raw); err != nil {
+				return err
+			}
+		}
+		if version == 0 {
+			return nil
+		}
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		return meta.Put(schemaVersionKey, boltKey(uint64(version)))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateBolt(t *testing.T) {
+	var v0 struct {
+		Users []json.RawMessage `json:"diabler"`
+	}
+	if err := json.Unmarshal(readFixture(t, "v0.json"), &v0); err != nil {
+		t.Fatal(err)
+	}
+	fPath := filepath.Join(t.TempDir(), "diabler.db")
+	// Bolt databases never had the "diabler" key, their records are stored one by one
+	writeBolt(t, fPath, v0.Users[:len(v0.Users)-1], 0)
+
+	b, err := OpenBolt(fPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := b.Users()
+	b.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Records are ordered by their big endian keys, negative chat IDs come last
+	want := []User{wantV0Users[2], wantV0Users[0], wantV0Users[1]}
+	if !reflect.DeepEqual(users, want) {
+		t.Errorf("Users() = %+v, want %+v", users, want)
+	}
+
+	newer := filepath.Join(t.TempDir(), "newer.db")
+	writeBolt(t, newer, nil, 99)
+	if b, err := OpenBolt(newer); err == nil {
+		b.Close()
+		t.Fatal("OpenBolt() opened a database of a newer schema version")
+	}
+}
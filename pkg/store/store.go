@@ -0,0 +1,178 @@
+// Package store keeps bot users and World Boss spawn reports.
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tetra5/diabler/pkg/d4/events"
+)
+
+const (
+	MaxAlarms = 5 // Per event kind
+)
+
+var ErrNotFound = errors.New("user not found")
+
+// Store is safe for concurrent use.
+type Store interface {
+	GetUser(chatID int64) (User, error) // ErrNotFound if there is no such user
+	PutUser(u User) error
+	// UpdateUser applies fn to the stored user and saves the result unless fn fails.
+	UpdateUser(chatID int64, fn func(u *User) error) (User, error)
+	Users() ([]User, error)
+	Observations() ([]events.Observation, error)
+	AddObservation(o events.Observation) error
+	Jobs() ([]Job, error) // Pending alarm jobs ordered by due time
+	// PutJob queues a job or replaces the pending one with the same ID.
+	PutJob(j Job) error
+	// DeleteJob removes a delivered or dropped job, a missing one is not an error.
+	DeleteJob(id string) error
+	// Quarantined returns records found corrupt when the store was opened. They are kept
+	// aside instead of being loaded so they cannot break lookups of other users.
+	Quarantined() []QuarantinedRecord
+	Close() error
+}
+
+type QuarantinedRecord struct {
+	Reason string          `json:"reason"`
+	Record json.RawMessage `json:"record"`
+}
+
+// User alarms are lead times in minutes before an event, from the earliest to the latest one.
+// Zero goes off when the event starts.
+type User struct {
+	ChatID        int64    `json:"chat_id"`
+	TimeZone      string   `json:"time_zone,omitempty"` // IANA name such as "Europe/Berlin", UTC if empty
+	WBAlarms      []int    `json:"wb_alarms,omitempty"`
+	WBMutedBosses []string `json:"wb_muted_bosses,omitempty"` // No World Boss alarms for these
+	HTAlarms      []int    `json:"ht_alarms,omitempty"`
+	LGAlarms      []int    `json:"lg_alarms,omitempty"`
+	// QuietHours is in local time, nil if the user has none. Alarms falling into them are dropped
+	// or, with QuietDigest, sent in a single message once they are over.
+	QuietHours  *events.TimeWindow `json:"quiet_hours,omitempty"`
+	QuietDigest bool               `json:"quiet_digest,omitempty"`
+}
+
+func NewUser(chatID int64) (user User) {
+	return User{
+		ChatID: chatID,
+	}
+}
+
+// Validate reports why a user record cannot be used.
+func (u *User) Validate() error {
+	var errs []error
+	if u.ChatID == 0 {
+		errs = append(errs, errors.New("chat ID is missing"))
+	}
+	if _, err := LoadLocation(u.TimeZone); err != nil {
+		errs = append(errs, err)
+	}
+	for _, alarms := range [][]int{u.WBAlarms, u.HTAlarms, u.LGAlarms} {
+		if len(alarms) > MaxAlarms {
+			errs = append(errs, fmt.Errorf("%d alarms of an event, at most %d are allowed", len(alarms), MaxAlarms))
+		}
+		for _, lead := range alarms {
+			if lead < 0 {
+				errs = append(errs, fmt.Errorf("alarm lead time %d is negative", lead))
+			}
+		}
+	}
+	if u.QuietHours != nil && u.QuietHours.Start == u.QuietHours.End {
+		errs = append(errs, errors.New("quiet hours are empty"))
+	}
+	return errors.Join(errs...)
+}
+
+// decodeUser decodes and validates a single user record.
+func decodeUser(raw []byte) (u User, err error) {
+	if err := json.Unmarshal(raw, &u); err != nil {
+		return u, err
+	}
+	return u, u.Validate()
+}
+
+// Alarms returns a pointer to the user's alarm lead times for the given event kind.
+func (u *User) Alarms(kind events.Kind) *[]int {
+	switch kind {
+	case events.KindHelltide:
+		return &u.HTAlarms
+	case events.KindLegion:
+		return &u.LGAlarms
+	default:
+		return &u.WBAlarms
+	}
+}
+
+// HasAlarm reports whether the user has an alarm lead minutes before events of the given kind.
+func (u *User) HasAlarm(kind events.Kind, lead int) bool {
+	for _, l := range *u.Alarms(kind) {
+		if l == lead {
+			return true
+		}
+	}
+	return false
+}
+
+// Location is the user's time zone, UTC if it cannot be loaded.
+func (u *User) Location() *time.Location {
+	loc, err := LoadLocation(u.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+var locations sync.Map // Zone name to *time.Location, loading one parses tzdata every time
+
+// LoadLocation is time.LoadLocation with a cache, "" is UTC.
+func LoadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// InQuietHours reports whether t falls into the user's quiet hours.
+func (u *User) InQuietHours(t time.Time) bool {
+	return u.QuietHours != nil && u.QuietHours.ContainsIn(t, u.Location())
+}
+
+// MutesBoss reports whether the user does not want alarms for the given World Boss.
+func (u *User) MutesBoss(name string) bool {
+	for _, muted := range u.WBMutedBosses {
+		if strings.EqualFold(muted, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// WantsAlarm reports whether the user wants alarms for ev.
+func (u *User) WantsAlarm(ev events.Event) bool {
+	return ev.Kind() != events.KindWorldBoss || !u.MutesBoss(ev.Title())
+}
+
+// Open opens a store by its kind: "json", "bolt" or "memory". fPath is ignored by the latter.
+func Open(kind string, fPath string) (Store, error) {
+	switch kind {
+	case "json":
+		return OpenJSON(fPath)
+	case "bolt":
+		return OpenBolt(fPath)
+	case "memory":
+		return NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("unknown store %q", kind)
+	}
+}